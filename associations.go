@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"golang.org/x/time/rate"
+)
+
+type associationRow struct {
+	MeetingID       string `json:"meeting_id"`
+	AgendaContentID string `json:"agenda_content_id"`
+	URL             string `json:"url"`
+}
+
+// staleAssociationsWhere matches meeting_external_content_urls rows for
+// agenda content that's no longer any of the meeting's current agendas,
+// such as attachments of an agenda since revised.
+const staleAssociationsWhere = `agenda_content_id not in (
+		select agenda_content_id from meetings where meetings.id=meeting_external_content_urls.meeting_id and agenda_content_id is not null
+		union select agenda_content_id from meeting_agendas where meeting_agendas.meeting_id=meeting_external_content_urls.meeting_id and agenda_content_id is not null)`
+
+// checkAssociations writes the external content URL associations whose
+// agenda content isn't one of the meeting's current agendas. With -apply
+// they're deleted, so queries for a meeting's attachments through
+// meeting_external_content_urls only see its current agendas'. The URLs and
+// their content are kept.
+func checkAssociations(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	rows, err := db.QueryContext(ctx, "select meeting_id, agenda_content_id, external_content_url from meeting_external_content_urls where "+staleAssociationsWhere+" order by meeting_id, agenda_content_id, external_content_url")
+	if err != nil {
+		return fmt.Errorf("check-associations: %w", err)
+	}
+	defer rows.Close()
+
+	var out []associationRow
+	for rows.Next() {
+		var r associationRow
+		if err := rows.Scan(&r.MeetingID, &r.AgendaContentID, &r.URL); err != nil {
+			return fmt.Errorf("check-associations: %w", err)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("check-associations: %w", err)
+	}
+	rows.Close()
+	cfg.summary.add("stale", len(out))
+
+	header := []string{"meeting_id", "agenda_content_id", "url"}
+	err = writeResults(cfg.format, out, header, func(r associationRow) []string {
+		return []string{r.MeetingID, r.AgendaContentID, r.URL}
+	})
+	if err != nil {
+		return fmt.Errorf("check-associations: %w", err)
+	}
+
+	if !cfg.apply {
+		log.Printf("check-associations: found stale=%v; run with -apply to delete them", len(out))
+		return nil
+	}
+	res, err := db.ExecContext(ctx, "delete from meeting_external_content_urls where "+staleAssociationsWhere)
+	if err != nil {
+		return fmt.Errorf("check-associations: delete: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("check-associations: delete: %w", err)
+	}
+	log.Printf("check-associations: deleted stale=%v", n)
+	return nil
+}
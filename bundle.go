@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/time/rate"
+)
+
+type searchBundle struct {
+	Docs  []searchBundleDoc `json:"docs"`
+	Index map[string][]int  `json:"index"` // token to indexes into Docs
+}
+
+type searchBundleDoc struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Date string `json:"date"`
+}
+
+// exportSearchBundle writes a compact inverted index of meeting types, dates,
+// and agenda text to stdout for use by client-side search.
+func exportSearchBundle(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	const q = `select m.id, m.type, m.starts, coalesce(c.text, '') from meetings m left join meeting_agenda_content c on c.id=m.agenda_content_id order by m.starts, m.id`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("search bundle: select: %w", err)
+	}
+	defer rows.Close()
+
+	bundle := searchBundle{Index: make(map[string][]int)}
+	for rows.Next() {
+		var (
+			d      searchBundleDoc
+			starts time.Time
+			text   string
+		)
+		if err := rows.Scan(&d.ID, &d.Type, newTimeValue(&starts), &text); err != nil {
+			return fmt.Errorf("search bundle: scan: %w", err)
+		}
+		d.Date = starts.Format(dateFormat)
+		if cfg.bundleTextLen > 0 && len(text) > cfg.bundleTextLen {
+			text = strings.ToValidUTF8(text[:cfg.bundleTextLen], "")
+		}
+
+		i := len(bundle.Docs)
+		bundle.Docs = append(bundle.Docs, d)
+		for _, t := range searchTokens(d.Type + " " + d.Date + " " + text) {
+			bundle.Index[t] = append(bundle.Index[t], i)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("search bundle: select: %w", err)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(bundle); err != nil {
+		return fmt.Errorf("search bundle: encode: %w", err)
+	}
+	return nil
+}
+
+// searchTokens returns the distinct lowercased words in s, sorted. Single
+// characters are dropped since they're not useful to search on.
+func searchTokens(s string) []string {
+	seen := make(map[string]struct{})
+	for _, f := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(f)) < 2 {
+			continue
+		}
+		seen[f] = struct{}{}
+	}
+	tokens := make([]string, 0, len(seen))
+	for t := range seen {
+		tokens = append(tokens, t)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
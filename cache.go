@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// cacheContent copies f into dir named by contentID, unless it's already
+// there. Since names are content addressed, identical bytes are only stored
+// once.
+func cacheContent(dir, contentID string, f *os.File) error {
+	fn := filepath.Join(dir, contentID)
+	if _, err := os.Stat(fn); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tf, err := os.CreateTemp(dir, contentID+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tf.Name())
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return err
+	}
+	if _, err := io.Copy(tf, f); err != nil {
+		tf.Close()
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return os.Rename(tf.Name(), fn)
+}
+
+// cachedURLContent returns the cached content for u, previously fetched with
+// content ID contentID, along with the metadata stored when it was fetched.
+// ok is false if it isn't in the cache.
+func cachedURLContent(db *sql.DB, dir, u, contentID string) (_ urlContent, ok bool, _ error) {
+	f, err := os.Open(filepath.Join(dir, contentID))
+	if errors.Is(err, os.ErrNotExist) {
+		return urlContent{}, false, nil
+	}
+	if err != nil {
+		return urlContent{}, false, fmt.Errorf("open cached %v: %w", contentID, err)
+	}
+
+	uc := urlContent{f: f, contentID: contentID, cached: true}
+	var (
+		contentType, etag sql.NullString
+		size              sql.NullInt64
+	)
+	if err := db.QueryRow("select content_type, size, last_modified, etag from external_content_urls where url=?", u).Scan(&contentType, &size, newTimeValue(&uc.lastModified), &etag); err != nil {
+		f.Close()
+		return urlContent{}, false, fmt.Errorf("select cached %v metadata: %w", contentID, err)
+	}
+	uc.contentType = contentType.String
+	uc.size = size.Int64
+	uc.etag = etag.String
+	return uc, true, nil
+}
+
+// copyFile copies src to dst, replacing dst atomically so a partial copy is
+// never seen there.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tf, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tf.Name())
+
+	if _, err := io.Copy(tf, in); err != nil {
+		tf.Close()
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tf.Name(), dst)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Kinds of changelog entries.
+const (
+	changeNewMeeting     = "new_meeting"
+	changeAgendaUpdated  = "agenda_updated"
+	changeMeetingUpdated = "meeting_updated" // schedule note, minutes or video changed
+	changeNewAttachment  = "new_attachment"
+)
+
+type changelogEntry struct {
+	At          time.Time `json:"at"`
+	Kind        string    `json:"kind"`
+	MeetingID   string    `json:"meeting_id"`
+	MeetingType string    `json:"meeting_type"`
+	Starts      string    `json:"starts"`
+	Summary     string    `json:"summary"`
+	URL         string    `json:"url,omitempty"`
+}
+
+// writeChangelog writes what changed between -since and -until, oldest
+// first: new meetings, agenda and other meeting updates from
+// meeting_versions, and attachments first seen. -since and -until may be
+// dates in -tz or RFC 3339 timestamps, so a feed can ask for what changed
+// since it last ran. With -format text it's one readable line per change,
+// otherwise json or csv. Changed attachments are left out, since the
+// history of their content isn't kept.
+func writeChangelog(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	since, until := cfg.observedRange()
+
+	out, err := meetingChanges(ctx, db, since, until)
+	if err != nil {
+		return fmt.Errorf("changelog: %w", err)
+	}
+	attachments, err := newAttachments(ctx, db, since, until)
+	if err != nil {
+		return fmt.Errorf("changelog: %w", err)
+	}
+	out = append(out, attachments...)
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].At.Equal(out[j].At) {
+			return out[i].At.Before(out[j].At)
+		}
+		return out[i].MeetingID < out[j].MeetingID
+	})
+	for i := range out {
+		out[i].At = out[i].At.In(cfg.tz)
+		cfg.summary.add(out[i].Kind, 1)
+	}
+
+	if cfg.format == "text" {
+		for _, e := range out {
+			fmt.Printf("%v %v %v (%v): %v\n", e.At.Format("2006-01-02 15:04"), e.MeetingType, e.Starts, e.MeetingID, e.Summary)
+		}
+		return nil
+	}
+	header := []string{"at", "kind", "meeting_id", "meeting_type", "starts", "summary", "url"}
+	err = writeResults(cfg.format, out, header, func(e changelogEntry) []string {
+		return []string{e.At.Format(time.RFC3339), e.Kind, e.MeetingID, e.MeetingType, e.Starts, e.Summary, e.URL}
+	})
+	if err != nil {
+		return fmt.Errorf("changelog: %w", err)
+	}
+	return nil
+}
+
+// meetingChanges returns an entry for each meeting version observed from
+// since until before until, compared with the meeting's version before it.
+func meetingChanges(ctx context.Context, db *sql.DB, since, until string) ([]changelogEntry, error) {
+	const q = `select v.meeting_id, v.observed, v.prev_observed is not null, m.type, m.starts,
+		coalesce(v.schedule_note, ''), coalesce(v.prev_note, ''), coalesce(v.minutes_url, ''), coalesce(v.prev_minutes, ''), coalesce(v.video_url, ''), coalesce(v.prev_video, ''),
+		coalesce(v.agenda_url, ''), coalesce(v.agenda_content_id, ''), coalesce(v.prev_content_id, '')
+		from (
+			select *, lag(observed) over w as prev_observed, lag(schedule_note) over w as prev_note, lag(minutes_url) over w as prev_minutes,
+				lag(video_url) over w as prev_video, lag(agenda_content_id) over w as prev_content_id
+			from meeting_versions window w as (partition by meeting_id order by observed)
+		) v join meetings m on m.id=v.meeting_id
+		where v.observed >= ? and v.observed < ?`
+	rows, err := db.QueryContext(ctx, q, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("meeting versions: %w", err)
+	}
+	defer rows.Close()
+
+	type agendaDiff struct {
+		i             int
+		prevID, curID string
+	}
+	var (
+		out   []changelogEntry
+		diffs []agendaDiff
+	)
+	for rows.Next() {
+		var (
+			e                                 changelogEntry
+			starts                            time.Time
+			hasPrev                           bool
+			note, prevNote, minutes, prevMins string
+			video, prevVideo, agendaURL       string
+			contentID, prevContentID          string
+		)
+		if err := rows.Scan(&e.MeetingID, newTimeValue(&e.At), &hasPrev, &e.MeetingType, newTimeValue(&starts), &note, &prevNote, &minutes, &prevMins, &video, &prevVideo, &agendaURL, &contentID, &prevContentID); err != nil {
+			return nil, fmt.Errorf("meeting versions: %w", err)
+		}
+		e.Starts = starts.Format("2006-01-02 15:04")
+
+		if !hasPrev {
+			e.Kind, e.Summary, e.URL = changeNewMeeting, "new meeting", agendaURL
+			if note != "" {
+				e.Summary += ", " + note
+			}
+			out = append(out, e)
+			continue
+		}
+
+		var changed []string
+		if note != prevNote {
+			changed = append(changed, fmt.Sprintf("schedule note now %q", note))
+		}
+		if minutes != prevMins && minutes != "" {
+			changed = append(changed, "minutes posted")
+		}
+		if video != prevVideo && video != "" {
+			changed = append(changed, "video posted")
+		}
+		if len(changed) > 0 {
+			me := e
+			me.Kind, me.Summary = changeMeetingUpdated, strings.Join(changed, ", ")
+			out = append(out, me)
+		}
+		if contentID != prevContentID {
+			e.Kind, e.Summary, e.URL = changeAgendaUpdated, "agenda updated", agendaURL
+			out = append(out, e)
+			diffs = append(diffs, agendaDiff{len(out) - 1, prevContentID, contentID})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("meeting versions: %w", err)
+	}
+	rows.Close()
+
+	for _, d := range diffs {
+		var prev, cur sql.NullString
+		const tq = `select (select text from meeting_agenda_content where id=?), (select text from meeting_agenda_content where id=?)`
+		if err := db.QueryRowContext(ctx, tq, d.prevID, d.curID).Scan(&prev, &cur); err != nil {
+			return nil, fmt.Errorf("agenda text: %w", err)
+		}
+		if prev.Valid && cur.Valid {
+			out[d.i].Summary += ": " + agendaDiffSummary(prev.String, cur.String)
+		}
+	}
+	return out, nil
+}
+
+// newAttachments returns an entry for each meeting's attachment or document
+// first seen from since until before until.
+func newAttachments(ctx context.Context, db *sql.DB, since, until string) ([]changelogEntry, error) {
+	const q = `select distinct mu.meeting_id, u.added, m.type, m.starts, u.url, coalesce(c.title, mu.title, u.link_text, '')
+		from external_content_urls u
+		join meeting_external_content_urls mu on mu.external_content_url=u.url
+		join meetings m on m.id=mu.meeting_id
+		left join external_content c on c.id=u.external_content_id
+		where u.added >= ? and u.added < ?`
+	rows, err := db.QueryContext(ctx, q, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var out []changelogEntry
+	for rows.Next() {
+		var (
+			e      changelogEntry
+			starts time.Time
+			title  string
+		)
+		if err := rows.Scan(&e.MeetingID, newTimeValue(&e.At), &e.MeetingType, newTimeValue(&starts), &e.URL, &title); err != nil {
+			return nil, fmt.Errorf("attachments: %w", err)
+		}
+		e.Starts = starts.Format("2006-01-02 15:04")
+		e.Kind = changeNewAttachment
+		e.Summary = "new attachment"
+		if title = strings.Join(strings.Fields(title), " "); title != "" {
+			e.Summary += " " + fmt.Sprintf("%q", title)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("attachments: %w", err)
+	}
+	return out, nil
+}
+
+// agendaDiffSummary describes how cur differs from prev in one line: how
+// much changed, as agendaChange measures it, the words added and removed,
+// and the first few added words.
+func agendaDiffSummary(prev, cur string) string {
+	counts := make(map[string]int)
+	for _, w := range strings.Fields(prev) {
+		if w = normalizeContentText(w); w != "" {
+			counts[w]++
+		}
+	}
+	var (
+		added  int
+		sample []string
+	)
+	for _, f := range strings.Fields(cur) {
+		w := normalizeContentText(f)
+		if w == "" {
+			continue
+		}
+		if counts[w] > 0 {
+			counts[w]--
+			continue
+		}
+		added++
+		if len(sample) < 5 {
+			sample = append(sample, f)
+		}
+	}
+	var removed int
+	for _, n := range counts {
+		removed += n
+	}
+
+	s := fmt.Sprintf("%.0f%% changed, %v words added, %v removed", 100*agendaChange(prev, cur), added, removed)
+	if len(sample) > 0 {
+		s += fmt.Sprintf(", adding %q", strings.Join(sample, " "))
+	}
+	return s
+}
@@ -2,18 +2,30 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
 
-	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/base"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/commonmark"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/table"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/jxskiss/base62"
 	"github.com/yosssi/gohtml"
 	"golang.org/x/net/html"
 )
@@ -23,16 +35,29 @@ type MeetingURL struct {
 	URL  string
 }
 
+// MeetingDocument is a document listed with a meeting rather than linked
+// from its agenda.
+type MeetingDocument struct {
+	URL      string
+	Title    string
+	Sequence int // position in the source's list
+}
+
 type MeetingEvent struct {
 	Date time.Time
 	Note string
+	End  time.Time // zero if unknown
 }
 
 type Meeting struct {
-	ID    string
-	Type  string
-	Event MeetingEvent
-	URLs  []MeetingURL
+	ID          string
+	Type        string
+	Name        string // specific name of this meeting, if the source has one
+	ClassName   string // eScribe's class for the meeting's body, disambiguates bodies sharing a name
+	SessionKind string
+	Event       MeetingEvent
+	URLs        []MeetingURL
+	Documents   []MeetingDocument // ordered by Sequence
 }
 
 func (m Meeting) URL(name string) string {
@@ -44,16 +69,202 @@ func (m Meeting) URL(name string) string {
 	return ""
 }
 
+// URLsNamed returns all URLs with name, in listed order. Meetings can have more
+// than one agenda, such as a revised agenda posted after the original.
+func (m Meeting) URLsNamed(name string) []string {
+	var out []string
+	for _, u := range m.URLs {
+		if u.Name == name {
+			out = append(out, u.URL)
+		}
+	}
+	return out
+}
+
 type MeetingAgenda struct {
-	ContentHTML string // should be consistently formatted
-	ContentText string // should be consistently formatted
-	ContentURLs []string
+	ContentHTML    string // should be consistently formatted
+	ContentText    string // should be consistently formatted
+	ContentURLs    []string
+	ContentURLText map[string]string // link text by URL, when there was any
+	ContentID      string            // if set, used instead of hashing ContentHTML
+	Items          []AgendaItem
+	ResolvedURL    string // where the agenda was found after any redirects, if known
+	Language       string // primary language subtag from the page's lang attribute, like "en" or "fr", if it has one
+	// AttachmentCount is how many distinct attachments the agenda links
+	// to, if known. It's kept even if ContentURLs is later dropped.
+	AttachmentCount *int
+
+	Validators Validators // from the agenda page response
+}
+
+func (a *MeetingAgenda) addContentURL(u, text string) {
+	a.ContentURLs = append(a.ContentURLs, u)
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return
+	}
+	if a.ContentURLText == nil {
+		a.ContentURLText = make(map[string]string)
+	}
+	if _, ok := a.ContentURLText[u]; !ok {
+		a.ContentURLText[u] = text
+	}
+}
+
+// countAttachments sets AttachmentCount from the distinct ContentURLs.
+func (a *MeetingAgenda) countAttachments() {
+	seen := make(map[string]bool, len(a.ContentURLs))
+	for _, u := range a.ContentURLs {
+		seen[u] = true
+	}
+	n := len(seen)
+	a.AttachmentCount = &n
+}
+
+type AgendaItem struct {
+	Number      string
+	Title       string
+	VideoOffset *int // seconds into the meeting video, if known
+}
+
+var timecodeRE = regexp.MustCompile(`\b(?:(\d{1,2}):)?(\d{1,2}):(\d{2})\b`)
+
+// escribeAgendaItems extracts the individual items from eScribe agenda
+// content. Some agendas mark items with a timecode into the meeting video in
+// an element with a TimeStamp or Timecode class.
+func escribeAgendaItems(content *goquery.Selection) []AgendaItem {
+	var items []AgendaItem
+	for _, s := range nodes(content.Find(".AgendaItem")) {
+		item := AgendaItem{
+			Number: strings.TrimSpace(s.Find(".AgendaItemCounter").First().Text()),
+			Title:  strings.Join(strings.Fields(s.Find(".AgendaItemTitle").First().Text()), " "),
+		}
+		if item.Title == "" {
+			continue
+		}
+
+		tc := s.Find("[class]").FilterFunction(func(_ int, e *goquery.Selection) bool {
+			class := strings.ToLower(e.AttrOr("class", ""))
+			return strings.Contains(class, "timestamp") || strings.Contains(class, "timecode")
+		}).First()
+		if m := timecodeRE.FindStringSubmatch(tc.Text()); m != nil {
+			h, _ := strconv.Atoi(m[1])
+			mins, _ := strconv.Atoi(m[2])
+			sec, _ := strconv.Atoi(m[3])
+			offset := h*3600 + mins*60 + sec
+			item.VideoOffset = &offset
+		}
+
+		items = append(items, item)
+	}
+	return items
+}
+
+// Validators are the cache validators from a previous response, used to make
+// a conditional request.
+type Validators struct {
+	ETag         string
+	LastModified string
+	// HeaderHash hashes the ETag, Last-Modified and Content-Length headers,
+	// for servers that answer conditional requests with the whole page
+	// anyway. It's empty without an ETag or Last-Modified, since an
+	// unchanged length alone says little.
+	HeaderHash string
+}
+
+func (v Validators) setHeaders(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+func validatorsFrom(h http.Header) Validators {
+	v := Validators{ETag: h.Get("ETag"), LastModified: h.Get("Last-Modified")}
+	if v.ETag != "" || v.LastModified != "" {
+		sum := sha256.Sum224([]byte(v.ETag + "\n" + v.LastModified + "\n" + h.Get("Content-Length")))
+		v.HeaderHash = base62.EncodeToString(sum[:])
+	}
+	return v
+}
+
+// unchanged reports whether a response with validators cur has the same
+// headers as the one v came from, so it can be treated as not modified
+// without parsing it.
+func (v Validators) unchanged(cur Validators) bool {
+	return v.HeaderHash != "" && v.HeaderHash == cur.HeaderHash
 }
 
+// ErrNotModified is returned when a conditional request finds the resource
+// unchanged.
+var ErrNotModified = errors.New("not modified")
+
+// ParseError is returned when an agenda page was fetched but couldn't be
+// parsed. It carries the response so it can be kept for diagnosis.
+type ParseError struct {
+	Status int
+	Body   []byte
+	Err    error
+}
+
+func (e ParseError) Error() string { return e.Err.Error() }
+func (e ParseError) Unwrap() error { return e.Err }
+
 type Client struct {
-	Limiter func()
+	Limiter    func()
+	HTTPClient *http.Client // if nil, http.DefaultClient is used
+	Retry      Retrier      // for transient failures; the zero value doesn't retry
+	// Pretty formats agenda HTML with gohtml. Changing it changes agenda content IDs.
+	Pretty bool
+	// ContentHosts limits which links are collected as content URLs, see
+	// hostAllowed. If nil, defaultContentHosts is used.
+	ContentHosts []string
+	// NoOCR skips OCR of PDF agendas without a text layer.
+	NoOCR bool
+	// ListingCache, if set, keeps listing pages' meetings so unchanged pages
+	// can be fetched conditionally and reused.
+	ListingCache ListingCache
+	// MinDate and MaxDate bound plausible meeting dates, see
+	// checkMeetingDate. Zero means no bound.
+	MinDate, MaxDate time.Time
+}
+
+// checkMeetingDate returns an error if t is before min or after max, either
+// of which may be zero for no bound. A listed date that far off was likely
+// misparsed, and storing it would skew cutoffs based on dates.
+func checkMeetingDate(t, min, max time.Time) error {
+	if !min.IsZero() && t.Before(min) {
+		return fmt.Errorf("date %v is before %v", t.Format(dateFormat), min.Format(dateFormat))
+	}
+	if !max.IsZero() && t.After(max) {
+		return fmt.Errorf("date %v is after %v", t.Format(dateFormat), max.Format(dateFormat))
+	}
+	return nil
 }
 
+// ListingCache stores the meetings found on listing pages by page URL.
+type ListingCache interface {
+	Get(pageURL string) (_ ListingPage, ok bool, _ error)
+	Put(pageURL string, p ListingPage) error
+}
+
+// ListingPage is what Client.List found on a listing page.
+type ListingPage struct {
+	Version    int // listingPageVersion when the page was parsed
+	Validators Validators
+	Meetings   []Meeting
+	NextToken  string
+}
+
+// listingPageVersion versions how Client.List parses listing pages. Bump it
+// when that changes, so cached pages parsed the old way are fetched again.
+const listingPageVersion = 2
+
+// defaultContentHosts are where halifax.ca agendas link attachments from.
+var defaultContentHosts = []string{"www.halifax.ca/media", "cdn.halifax.ca"}
+
 func (c Client) List(ctx context.Context, token string) (_ []Meeting, nextToken string, _ error) {
 	u := "https://www.halifax.ca/city-hall/agendas-meetings-reports"
 	if token != "" {
@@ -69,21 +280,45 @@ func (c Client) List(ctx context.Context, token string) (_ []Meeting, nextToken
 		return nil, "", fmt.Errorf("new request: %w", err)
 	}
 
+	var cached ListingPage
+	if c.ListingCache != nil {
+		p, ok, err := c.ListingCache.Get(u)
+		if err != nil {
+			return nil, "", fmt.Errorf("listing cache: %w", err)
+		}
+		if ok && p.Version == listingPageVersion {
+			cached = p
+			cached.Validators.setHeaders(req)
+		}
+	}
+
 	if c.Limiter != nil {
 		c.Limiter()
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Retry.Do(c.HTTPClient, req)
 	if err != nil {
 		return nil, "", fmt.Errorf("get: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if cached.Version != 0 && resp.StatusCode == http.StatusNotModified {
+		return cached.Meetings, cached.NextToken, nil
+	}
+	body, err := readUnblockedBody(resp)
+	if err != nil {
+		return nil, "", err
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, "", fmt.Errorf("bad status %v", resp.StatusCode)
 	}
+	validators := validatorsFrom(resp.Header)
+	if cached.Version != 0 && cached.Validators.unchanged(validators) {
+		return cached.Meetings, cached.NextToken, nil
+	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
 		return nil, "", fmt.Errorf("new document: %w", err)
 	}
@@ -116,14 +351,18 @@ func (c Client) List(ctx context.Context, token string) (_ []Meeting, nextToken
 			mType = strings.TrimSpace(tr.Find("td:nth-child(2)").Text())
 		)
 
-		const dateFormat = "January 2, 2006"
-		mt, err := time.Parse(dateFormat, mTime)
+		mt, err := parseListingDate(mTime)
+		if err == nil {
+			err = checkMeetingDate(mt, c.MinDate, c.MaxDate)
+		}
 		if err != nil {
-			return nil, "", fmt.Errorf("bad meeting date format: %v", mTime)
+			log.Printf("skipping meeting url=%v type=%q: %v", u, mType, err)
+			continue
 		}
 
 		m.Type = mType
-		m.Event = MeetingEvent{mt, mNote}
+		m.SessionKind = sessionKind(mNote)
+		m.Event = MeetingEvent{Date: mt, Note: mNote}
 
 		urls := map[string]string{
 			"agenda":  abs(tr.Find("td:nth-child(3) a").AttrOr("href", "")),
@@ -137,12 +376,7 @@ func (c Client) List(ctx context.Context, token string) (_ []Meeting, nextToken
 			m.URLs = append(m.URLs, MeetingURL{k, s})
 		}
 
-		// sometimes we get things like https://www.halifax.ca/city-hallboards-committees-commissions
-		// try and account for that
-		id := strings.TrimPrefix(urls["agenda"], "https://www.halifax.ca/city-hall")
-		id = strings.TrimPrefix(id, "/")
-		id = strings.TrimPrefix(id, "http://legacycontent.halifax.ca/council/")
-		m.ID = id
+		m.ID = meetingID(urls["agenda"], mType, mt)
 
 		meetings = append(meetings, m)
 	}
@@ -150,43 +384,113 @@ func (c Client) List(ctx context.Context, token string) (_ []Meeting, nextToken
 	nextLink := doc.Find("#block-views-block-meetings-listings-block-1 li.pager__item.pager__item--next > a")
 	nextToken = abs(nextLink.AttrOr("href", ""))
 
+	// Only cache pages that look right, an empty page may be a layout
+	// change that should be looked at again next time.
+	if c.ListingCache != nil && len(meetings) > 0 && (validators.ETag != "" || validators.LastModified != "") {
+		p := ListingPage{Version: listingPageVersion, Validators: validators, Meetings: meetings, NextToken: nextToken}
+		if err := c.ListingCache.Put(u, p); err != nil {
+			return nil, "", fmt.Errorf("listing cache: %w", err)
+		}
+	}
+
 	return meetings, nextToken, nil
 }
 
-func (c Client) Agenda(ctx context.Context, agendaURL string) (MeetingAgenda, error) {
+// canonicalizeMeetingURL returns the meeting ID for a halifax.ca agenda URL,
+// the part after https://www.halifax.ca/city-hall/. It also handles the
+// known malformed forms: the slash after city-hall missing (as in
+// https://www.halifax.ca/city-hallboards-committees-commissions/...) and
+// http://legacycontent.halifax.ca/council/ links for older meetings. Other
+// URLs, such as http:// or non-www ones, are returned as-is so the IDs
+// already stored for them don't change.
+func canonicalizeMeetingURL(u string) string {
+	id := strings.TrimPrefix(u, "https://www.halifax.ca/city-hall")
+	id = strings.TrimPrefix(id, "/")
+	return strings.TrimPrefix(id, "http://legacycontent.halifax.ca/council/")
+}
+
+// meetingID returns the ID for a halifax.ca listing row. It's usually the
+// canonical agenda URL, but some rows link a section page such as
+// https://www.halifax.ca/city-hall/boards-committees-commissions, or nothing,
+// instead of the meeting's own page. Using those as-is would give different
+// meetings the same ID, so the row's type and date are appended to tell them
+// apart.
+func meetingID(agendaURL, typ string, date time.Time) string {
+	id := canonicalizeMeetingURL(agendaURL)
+	if strings.Contains(id, "/") {
+		return id
+	}
+	if id == "" {
+		id = "meeting"
+	}
+	when := date.Format("2006-01-02")
+	if h, m, _ := date.Clock(); h != 0 || m != 0 {
+		when = date.Format("2006-01-02-1504")
+	}
+	return id + "/" + idSlug(typ) + "-" + when
+}
+
+var idSlugRE = regexp.MustCompile(`[^a-z0-9]+`)
+
+func idSlug(s string) string {
+	return strings.Trim(idSlugRE.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func (c Client) Agenda(ctx context.Context, agendaURL string, prev Validators) (MeetingAgenda, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", agendaURL, nil)
 	if err != nil {
 		return MeetingAgenda{}, fmt.Errorf("new request: %w", err)
 	}
+	prev.setHeaders(req)
 
 	if c.Limiter != nil {
 		c.Limiter()
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Retry.Do(c.HTTPClient, req)
 	if err != nil {
 		return MeetingAgenda{}, fmt.Errorf("get: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return MeetingAgenda{}, ErrNotModified
+	}
+	body, err := readUnblockedBody(resp)
+	if err != nil {
+		return MeetingAgenda{}, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return MeetingAgenda{}, fmt.Errorf("bad status %v", resp.StatusCode)
 	}
+	if prev.unchanged(validatorsFrom(resp.Header)) {
+		return MeetingAgenda{}, ErrNotModified
+	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	// Some meetings only have a PDF agenda, linked straight from the listing.
+	if bytes.HasPrefix(body, []byte("%PDF-")) {
+		agenda, err := pdfAgenda(ctx, body, c.NoOCR)
+		if err != nil {
+			return MeetingAgenda{}, fmt.Errorf("url=%v pdf agenda: %w", agendaURL, err)
+		}
+		agenda.Validators = validatorsFrom(resp.Header)
+		return agenda, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		return MeetingAgenda{}, fmt.Errorf("new document: %w", err)
+		return MeetingAgenda{}, ParseError{resp.StatusCode, body, fmt.Errorf("new document: %w", err)}
 	}
 
 	content := doc.Find("#block-halifax-content > div > article > div")
-	contentHTML, err := content.Html()
+	contentHTML, contentID, err := agendaHTML(content, agendaURL, c.Pretty)
 	if err != nil {
 		return MeetingAgenda{}, fmt.Errorf("getting content: %w", err)
 	}
-	contentHTML = gohtml.Format(contentHTML)
 
 	if len(contentHTML) == 0 {
-		return MeetingAgenda{}, fmt.Errorf("url=%v did not find content", agendaURL)
+		return MeetingAgenda{}, ParseError{resp.StatusCode, body, fmt.Errorf("url=%v did not find content", agendaURL)}
 	}
 
 	contentLines := strings.Split(strings.TrimSpace(content.Text()), "\n")
@@ -199,7 +503,7 @@ func (c Client) Agenda(ctx context.Context, agendaURL string) (MeetingAgenda, er
 		contentText += l + "\n"
 	}
 
-	agenda := MeetingAgenda{ContentHTML: contentHTML, ContentText: contentText}
+	agenda := MeetingAgenda{ContentHTML: contentHTML, ContentID: contentID, ContentText: contentText, Language: pageLanguage(doc), Validators: validatorsFrom(resp.Header)}
 
 	agendaURLU, err := url.Parse(agendaURL)
 	if err != nil {
@@ -208,17 +512,72 @@ func (c Client) Agenda(ctx context.Context, agendaURL string) (MeetingAgenda, er
 
 	for _, a := range nodes(content.Find("a")) {
 		href := abs(agendaURLU, a.AttrOr("href", ""))
-		if !strings.HasPrefix(href, "https://www.halifax.ca/media") {
+		hosts := c.ContentHosts
+		if hosts == nil {
+			hosts = defaultContentHosts
+		}
+		if !hostAllowed(href, hosts) {
 			continue
 		}
-		agenda.ContentURLs = append(agenda.ContentURLs, href)
+		agenda.addContentURL(href, a.Text())
 	}
+	agenda.countAttachments()
 
 	return agenda, nil
 }
 
+// pdfAgenda extracts an agenda from PDF bytes. The text is kept as-is and
+// also wrapped in a pre element as the agenda HTML, which the content ID is
+// hashed from like any other agenda.
+func pdfAgenda(ctx context.Context, b []byte, noOCR bool) (MeetingAgenda, error) {
+	f, err := os.CreateTemp("", "pdfAgenda")
+	if err != nil {
+		return MeetingAgenda{}, fmt.Errorf("create temp: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		return MeetingAgenda{}, fmt.Errorf("write temp: %w", err)
+	}
+
+	p, err := processPDF(ctx, f, ocrOptions{skip: noOCR})
+	if err != nil {
+		return MeetingAgenda{}, err
+	}
+	if p.text == "" {
+		return MeetingAgenda{}, errors.New("no text")
+	}
+	return MeetingAgenda{
+		ContentHTML: "<pre>" + html.EscapeString(p.text) + "</pre>",
+		ContentText: p.text + "\n",
+	}, nil
+}
+
 type EscribeClient struct {
-	Limiter func()
+	Limiter    func()
+	HTTPClient *http.Client // if nil, http.DefaultClient is used
+	Retry      Retrier      // for transient failures; the zero value doesn't retry
+	// Pretty formats agenda HTML with gohtml. Changing it changes agenda content IDs.
+	Pretty bool
+	// ContentHosts limits which links are collected as content URLs, see
+	// hostAllowed. If nil, all attachment links are collected.
+	ContentHosts []string
+	// Start and End bound the calendar listed. If zero, they're a year
+	// before and after now.
+	Start, End time.Time
+	// PrefetchNext also lists the year after End, to catch meetings
+	// scheduled far ahead.
+	PrefetchNext bool
+	// PrintView parses agendas from their print view when it has the
+	// agenda, as it lacks the interactive page's scripting. Changing it
+	// changes agenda content IDs.
+	PrintView bool
+	// NoOCR skips OCR of PDF agendas without a text layer.
+	NoOCR bool
+	// MinDate and MaxDate bound plausible meeting dates, see
+	// checkMeetingDate. Zero means no bound.
+	MinDate, MaxDate time.Time
 }
 
 func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nextToken string, _ error) {
@@ -226,10 +585,47 @@ func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nex
 		return nil, "", fmt.Errorf("escribe does not support pagination")
 	}
 
+	now := time.Now()
+	start, end := c.Start, c.End
+	if start.IsZero() {
+		start = now.AddDate(-1, 0, 0)
+	}
+	if end.IsZero() {
+		end = now.AddDate(1, 0, 0)
+	}
+	meetings, err := c.listWindow(ctx, start, end)
+	if err != nil {
+		return nil, "", err
+	}
+	if !c.PrefetchNext {
+		return meetings, "", nil
+	}
+
+	// Listing stops at the first meeting older than it needs, expecting
+	// newest first, so the further window's meetings go first. A meeting
+	// on the boundary may be in both.
+	next, err := c.listWindow(ctx, end, end.AddDate(1, 0, 0))
+	if err != nil {
+		return nil, "", fmt.Errorf("next window: %w", err)
+	}
+	seen := make(map[string]bool, len(next))
+	for _, m := range next {
+		seen[m.ID] = true
+	}
+	for _, m := range meetings {
+		if !seen[m.ID] {
+			next = append(next, m)
+		}
+	}
+	return next, "", nil
+}
+
+// listWindow lists the meetings in the calendar from start to end.
+func (c EscribeClient) listWindow(ctx context.Context, start, end time.Time) ([]Meeting, error) {
 	const u = "https://pub-halifax.escribemeetings.com"
 	baseU, err := url.Parse(u)
 	if err != nil {
-		return nil, "", fmt.Errorf("parsing URL: %w", err)
+		return nil, fmt.Errorf("parsing URL: %w", err)
 	}
 
 	abs := func(su string) string {
@@ -243,23 +639,21 @@ func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nex
 		return baseU.ResolveReference(rel).String()
 	}
 
-	now := time.Now()
-
 	var body struct {
 		CalendarStartDate time.Time `json:"calendarStartDate"`
 		CalendarEndDate   time.Time `json:"calendarEndDate"`
 	}
-	body.CalendarStartDate = now.AddDate(-1, 0, 0)
-	body.CalendarEndDate = now.AddDate(1, 0, 0)
+	body.CalendarStartDate = start
+	body.CalendarEndDate = end
 
 	b, err := json.Marshal(body)
 	if err != nil {
-		return nil, "", fmt.Errorf("marshal: %w", err)
+		return nil, fmt.Errorf("marshal: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u+"/MeetingsCalendarView.aspx/GetAllMeetings", bytes.NewReader(b))
 	if err != nil {
-		return nil, "", fmt.Errorf("new request: %w", err)
+		return nil, fmt.Errorf("new request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -267,19 +661,19 @@ func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nex
 		c.Limiter()
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Retry.Do(c.HTTPClient, req)
 	if err != nil {
-		return nil, "", fmt.Errorf("get: %w", err)
+		return nil, fmt.Errorf("get: %w", err)
 	}
 	defer resp.Body.Close()
 
-	b, err = io.ReadAll(resp.Body)
+	b, err = readUnblockedBody(resp)
 	if err != nil {
-		return nil, "", fmt.Errorf("read body: %w", err)
+		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("bad status %v: %v", resp.StatusCode, string(b))
+		return nil, fmt.Errorf("bad status %v: %v", resp.StatusCode, string(b))
 	}
 
 	var respBody struct {
@@ -330,18 +724,29 @@ func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nex
 	}
 
 	if err := json.Unmarshal(b, &respBody); err != nil {
-		return nil, "", fmt.Errorf("unmarshal: %w", err)
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	// An empty window still has a list, so without one the response
+	// isn't what's expected.
+	if respBody.D == nil {
+		return nil, errors.New("response has no meetings list")
 	}
 
 	var meetings []Meeting
 	for _, dm := range respBody.D {
-		startDate, _, ok := strings.Cut(dm.StartDate, " ")
-		if !ok {
-			return nil, "", fmt.Errorf("bad start date %q", dm.StartDate)
-		}
-		date, err := time.Parse("2006/01/02", startDate)
+		date, err := parseEscribeTime(dm.StartDate)
 		if err != nil {
-			return nil, "", fmt.Errorf("bad date %q: %w", startDate, err)
+			return nil, fmt.Errorf("bad start date %q: %w", dm.StartDate, err)
+		}
+		if err := checkMeetingDate(date, c.MinDate, c.MaxDate); err != nil {
+			log.Printf("skipping meeting id=%v type=%q: %v", dm.ID, dm.MeetingType, err)
+			continue
+		}
+		// An end that's missing or no later than the start says nothing
+		// about how long the meeting runs.
+		var end time.Time
+		if e, err := parseEscribeTime(dm.EndDate); err == nil && e.After(date) {
+			end = e
 		}
 
 		meetingType := dm.MeetingType
@@ -350,15 +755,33 @@ func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nex
 		}
 
 		m := Meeting{
-			ID:   dm.ID,
-			Type: meetingType,
+			ID:          dm.ID,
+			Type:        meetingType,
+			Name:        dm.MeetingName,
+			ClassName:   dm.ClassName,
+			SessionKind: sessionKind(dm.MeetingType + " " + dm.MeetingName),
 			Event: MeetingEvent{
 				Date: date,
+				End:  end,
 			},
 		}
+		pdfAgendaURLs := make(map[string]bool)
 		for _, dl := range dm.MeetingDocumentLink {
+			if dl.Type == "Agenda" && strings.EqualFold(dl.Format, ".pdf") && dl.URL != "" && !isFrench(dl.LanguageCode) {
+				pdfAgendaURLs[abs(dl.URL)] = true
+			}
+			// Everything but the HTML agenda itself and video is a
+			// document worth indexing, including attachments the agenda
+			// doesn't link to.
+			if dl.URL != "" && dl.Type != "Video" && !(dl.Type == "Agenda" && dl.Format == "HTML") {
+				m.Documents = append(m.Documents, MeetingDocument{URL: abs(dl.URL), Title: strings.TrimSpace(dl.Title), Sequence: escribeSequence(dl.Sequence)})
+			}
 			if dl.Type == "Agenda" && dl.Format == "HTML" {
-				m.URLs = append(m.URLs, MeetingURL{"agenda", abs(dl.URL)})
+				name := "agenda"
+				if isFrench(dl.LanguageCode) {
+					name = "agenda-fr"
+				}
+				m.URLs = append(m.URLs, MeetingURL{name, abs(dl.URL)})
 				continue
 			}
 			if dl.Type == "AdditionalDocuments" && dl.Format == ".pdf" && strings.Contains(dl.Title, "Minutes") {
@@ -371,46 +794,115 @@ func (c EscribeClient) List(ctx context.Context, token string) (_ []Meeting, nex
 			}
 		}
 
+		// A meeting with only a French agenda uses it as its main one.
+		if m.URL("agenda") == "" {
+			for i, u := range m.URLs {
+				if u.Name == "agenda-fr" {
+					m.URLs[i].Name = "agenda"
+					break
+				}
+			}
+		}
+		// Without an HTML agenda, a PDF one is used instead, taken out of
+		// the documents so it isn't also fetched as external content.
+		if m.URL("agenda") == "" {
+			for i, d := range m.Documents {
+				if pdfAgendaURLs[d.URL] {
+					m.URLs = append(m.URLs, MeetingURL{"agenda", d.URL})
+					m.Documents = slices.Delete(m.Documents, i, i+1)
+					break
+				}
+			}
+		}
+
+		slices.SortStableFunc(m.Documents, func(a, b MeetingDocument) int { return cmp.Compare(a.Sequence, b.Sequence) })
+
 		meetings = append(meetings, m)
 	}
 
-	return meetings, "", nil
+	return meetings, nil
 }
 
-func (c EscribeClient) Agenda(ctx context.Context, agendaURL string) (MeetingAgenda, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", agendaURL, nil)
-	if err != nil {
-		return MeetingAgenda{}, fmt.Errorf("new request: %w", err)
+// parseEscribeTime parses an eScribe StartDate or EndDate, such as
+// 2024/01/15 18:00:00, as local time labelled UTC like listing dates.
+func parseEscribeTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, f := range []string{"2006/01/02 15:04:05", "2006/01/02 15:04", "2006/01/02"} {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("unknown format")
+}
 
-	if c.Limiter != nil {
-		c.Limiter()
+// escribeSequence returns a MeetingDocumentLink Sequence, which is sometimes
+// a number and sometimes a string, as an int. Unknown values are 0.
+func escribeSequence(v any) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(v))
+		return n
 	}
+	return 0
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func (c EscribeClient) Agenda(ctx context.Context, agendaURL string, prev Validators) (MeetingAgenda, error) {
+	doc, header, body, err := c.agendaDocument(ctx, agendaURL, prev)
 	if err != nil {
-		return MeetingAgenda{}, fmt.Errorf("get: %w", err)
+		return MeetingAgenda{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return MeetingAgenda{}, fmt.Errorf("bad status %v", resp.StatusCode)
+	// Meetings without an HTML agenda are listed with their PDF one.
+	if bytes.HasPrefix(body, []byte("%PDF-")) {
+		agenda, err := pdfAgenda(ctx, body, c.NoOCR)
+		if err != nil {
+			return MeetingAgenda{}, fmt.Errorf("url=%v pdf agenda: %w", agendaURL, err)
+		}
+		agenda.Validators = validatorsFrom(header)
+		return agenda, nil
 	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return MeetingAgenda{}, fmt.Errorf("new document: %w", err)
+	// Some agenda links land on an interstitial page that redirects to the
+	// real agenda, follow those through.
+	resolvedURL := agendaURL
+	for hops := 0; doc.Find(".AgendaItems").Length() == 0 && hops < 3; hops++ {
+		next := interstitialURL(doc, resolvedURL)
+		if next == "" {
+			break
+		}
+		resolvedURL = next
+		doc, header, body, err = c.agendaDocument(ctx, resolvedURL, Validators{})
+		if err != nil {
+			return MeetingAgenda{}, fmt.Errorf("following interstitial to %v: %w", resolvedURL, err)
+		}
+	}
+	agendaURL = resolvedURL
+	language := pageLanguage(doc)
+
+	if c.PrintView {
+		if printURL := escribePrintURL(doc, agendaURL); printURL != "" {
+			pdoc, _, pbody, err := c.agendaDocument(ctx, printURL, Validators{})
+			switch {
+			case err != nil:
+				log.Printf("escribe print view url=%v: %v, using agenda page", printURL, err)
+			case pdoc.Find(".AgendaItems").Length() == 0:
+				log.Printf("escribe print view url=%v has no agenda items, using agenda page", printURL)
+			default:
+				doc, body, agendaURL = pdoc, pbody, printURL
+			}
+		}
 	}
 
 	content := doc.Find(".AgendaItems")
-	contentHTML, err := content.Html()
+	contentHTML, contentID, err := agendaHTML(content, agendaURL, c.Pretty)
 	if err != nil {
 		return MeetingAgenda{}, fmt.Errorf("getting content: %w", err)
 	}
-	contentHTML = gohtml.Format(contentHTML)
 
 	if len(contentHTML) == 0 {
-		return MeetingAgenda{}, fmt.Errorf("url=%v did not find content", agendaURL)
+		return MeetingAgenda{}, ParseError{http.StatusOK, body, fmt.Errorf("url=%v did not find content", agendaURL)}
 	}
 
 	content.Find(".AgendaItemIcons").Remove()
@@ -438,24 +930,254 @@ func (c EscribeClient) Agenda(ctx context.Context, agendaURL string) (MeetingAge
 		return MeetingAgenda{}, fmt.Errorf("getting content: %w", err)
 	}
 
-	md, err := htmltomarkdown.ConvertString(contentHTMLNoJavascript)
+	md, err := markdown(contentHTMLNoJavascript)
 	if err != nil {
 		return MeetingAgenda{}, fmt.Errorf("converting to markdown: %w", err)
 	}
 
-	agenda := MeetingAgenda{ContentHTML: contentHTML, ContentText: md}
+	agenda := MeetingAgenda{ContentHTML: contentHTML, ContentID: contentID, ContentText: md, Items: escribeAgendaItems(content), ResolvedURL: resolvedURL, Language: language, Validators: validatorsFrom(header)}
 
 	for _, a := range nodes(content.Find("a.Link")) {
 		href := abs(agendaURLU, a.AttrOr("href", ""))
 		if href == "" {
 			continue
 		}
-		agenda.ContentURLs = append(agenda.ContentURLs, href)
+		if c.ContentHosts != nil && !hostAllowed(href, c.ContentHosts) {
+			continue
+		}
+		agenda.addContentURL(href, a.Text())
 	}
+	agenda.countAttachments()
 
 	return agenda, nil
 }
 
+// agendaHTML returns the agenda HTML in s to store, sanitized and formatted
+// if pretty is set, along with its content ID. The ID is hashed from the
+// unsanitized HTML, so what sanitizing removes doesn't change content IDs.
+func agendaHTML(s *goquery.Selection, agendaURL string, pretty bool) (sanitized, contentID string, _ error) {
+	raw, err := s.Html()
+	if err != nil {
+		return "", "", err
+	}
+	sanitized, err = sanitizedHTML(s)
+	if err != nil {
+		return "", "", err
+	}
+	if pretty {
+		raw = prettyHTML(agendaURL, raw)
+		sanitized = prettyHTML(agendaURL, sanitized)
+	}
+	if sanitized == "" {
+		return "", "", nil
+	}
+	return sanitized, agendaContentID(raw), nil
+}
+
+// formatHTML is the formatter prettyHTML uses, replaceable so tests can
+// exercise its fallbacks.
+var formatHTML = gohtml.Format
+
+// prettyHTML formats s with gohtml. Agenda HTML is scraped and gohtml isn't
+// built for malformed input, so if it panics or its output loses any of s's
+// text, the failure is logged and s is returned unformatted rather than
+// ending the run.
+func prettyHTML(agendaURL, s string) (out string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("formatting agenda html url=%v: %v; leaving it unformatted", agendaURL, r)
+			out = s
+		}
+	}()
+	out = formatHTML(s)
+	if htmlText(out) != htmlText(s) {
+		log.Printf("formatting agenda html url=%v: formatted text differs; leaving it unformatted", agendaURL)
+		return s
+	}
+	return out
+}
+
+// htmlText returns the text of the HTML fragment s without whitespace, which
+// gohtml adds between elements, or "" if it doesn't parse.
+func htmlText(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(doc.Text()), "")
+}
+
+// markdownConverter converts agenda HTML to markdown. Agendas often carry
+// tables (vote tallies, schedules) so the GFM table plugin is enabled to keep
+// them as pipe tables rather than flattening cells into loose lines.
+var markdownConverter = converter.NewConverter(
+	converter.WithPlugins(
+		base.NewBasePlugin(),
+		commonmark.NewCommonmarkPlugin(),
+		table.NewTablePlugin(),
+	),
+)
+
+// markdown converts s from HTML to markdown. The output is used as-is: per-line
+// trimming would collapse table cell padding and indented code blocks.
+func markdown(s string) (string, error) {
+	return markdownConverter.ConvertString(s)
+}
+
+var (
+	listingDateSpaceRE   = regexp.MustCompile(`\s+`)
+	listingDateOrdinalRE = regexp.MustCompile(`(\d)(st|nd|rd|th)\b`)
+	listingDateFormats   = []string{"January 2, 2006", "Jan 2, 2006", "January 2 2006", "Jan 2 2006", "2006-01-02"}
+)
+
+// parseListingDate parses a meeting date from the halifax.ca listing, which
+// is normally like "January 2, 2006" but has been seen with abbreviations
+// like "Sept." and stray whitespace.
+func parseListingDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(listingDateSpaceRE.ReplaceAllString(s, " "))
+	s = listingDateOrdinalRE.ReplaceAllString(s, "$1")
+	s = strings.ReplaceAll(s, ".", "")
+	s = strings.ReplaceAll(s, " ,", ",")
+	if month, rest, ok := strings.Cut(s, " "); ok && strings.EqualFold(month, "Sept") {
+		s = "Sep " + rest
+	}
+	for _, f := range listingDateFormats {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad meeting date format: %q", s)
+}
+
+// Session kinds returned by sessionKind.
+const (
+	SessionRegular       = "Regular"
+	SessionSpecial       = "Special"
+	SessionContinuation  = "Continuation"
+	SessionPublicHearing = "Public Hearing"
+)
+
+// sessionKind classifies a meeting from free text such as its schedule note.
+// Public hearings are often also special meetings, so they're checked first.
+func sessionKind(s string) string {
+	s = strings.ToLower(s)
+	switch {
+	case strings.Contains(s, "public hearing"):
+		return SessionPublicHearing
+	case strings.Contains(s, "continuation"), strings.Contains(s, "continued"):
+		return SessionContinuation
+	case strings.Contains(s, "special"):
+		return SessionSpecial
+	}
+	return SessionRegular
+}
+
+// agendaDocument fetches and parses the page at agendaURL, returning its body
+// too.
+func (c EscribeClient) agendaDocument(ctx context.Context, agendaURL string, prev Validators) (*goquery.Document, http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", agendaURL, nil)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("new request: %w", err)
+	}
+	prev.setHeaders(req)
+
+	if c.Limiter != nil {
+		c.Limiter()
+	}
+
+	resp, err := c.Retry.Do(c.HTTPClient, req)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil, nil, ErrNotModified
+	}
+	body, err := readUnblockedBody(resp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, nil, fmt.Errorf("bad status %v", resp.StatusCode)
+	}
+	if prev.unchanged(validatorsFrom(resp.Header)) {
+		return nil, nil, nil, ErrNotModified
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, nil, ParseError{resp.StatusCode, body, fmt.Errorf("new document: %w", err)}
+	}
+	return doc, resp.Header, body, nil
+}
+
+// isEscribeURL reports whether u is on an eScribe meetings site.
+func isEscribeURL(u string) bool {
+	pu, err := url.Parse(u)
+	return err == nil && strings.HasSuffix(pu.Host, ".escribemeetings.com")
+}
+
+// escribePrintURL returns the URL of the print view of the agenda page doc
+// fetched from pageURL: the page's own print link if it has one, otherwise
+// pageURL with the print view's query parameter set. It returns "" if
+// pageURL isn't an eScribe meeting page.
+func escribePrintURL(doc *goquery.Document, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil || !strings.EqualFold(path.Base(base.Path), "Meeting.aspx") {
+		return ""
+	}
+	for _, a := range nodes(doc.Find("a[href]")) {
+		href := a.AttrOr("href", "")
+		if strings.HasPrefix(href, "javascript:") {
+			continue
+		}
+		if u, err := url.Parse(href); err == nil && strings.EqualFold(u.Query().Get("Print"), "Yes") {
+			return abs(base, href)
+		}
+	}
+	q := base.Query()
+	q.Set("Print", "Yes")
+	base.RawQuery = q.Encode()
+	return base.String()
+}
+
+// interstitialURL returns the absolute URL a page redirects to via a meta
+// refresh, or "" if it doesn't.
+func interstitialURL(doc *goquery.Document, pageURL string) string {
+	refresh := doc.Find("meta[http-equiv]").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return strings.EqualFold(s.AttrOr("http-equiv", ""), "refresh")
+	})
+	content := refresh.AttrOr("content", "")
+	i := strings.Index(strings.ToLower(content), "url=")
+	if i < 0 {
+		return ""
+	}
+	target := strings.Trim(strings.TrimSpace(content[i+len("url="):]), `'"`)
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	return abs(base, target)
+}
+
+// isFrench reports whether the language code, such as fr or fr-CA, is
+// French.
+func isFrench(code string) bool {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(code)), "-")
+	return primary == "fr"
+}
+
+// pageLanguage returns the primary subtag of doc's lang attribute, such as
+// "fr" for fr-CA, or "" if it has none.
+func pageLanguage(doc *goquery.Document) string {
+	lang := strings.TrimSpace(doc.Find("html").AttrOr("lang", ""))
+	primary, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	return primary
+}
+
 func nodes(s *goquery.Selection) []*goquery.Selection {
 	var out []*goquery.Selection
 	for _, n := range s.Nodes {
@@ -464,6 +1186,34 @@ func nodes(s *goquery.Selection) []*goquery.Selection {
 	return out
 }
 
+// hostAllowed reports whether u matches one of hosts. An entry is either a
+// host, matching any path on it, or a host followed by a path prefix such as
+// www.halifax.ca/media. Entries only match https URLs unless they start with
+// another scheme, as in http://example.com.
+func hostAllowed(u string, hosts []string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	for _, h := range hosts {
+		scheme := "https"
+		if s, rest, ok := strings.Cut(h, "://"); ok {
+			scheme, h = s, rest
+		}
+		if !strings.EqualFold(pu.Scheme, scheme) {
+			continue
+		}
+		host, prefix, _ := strings.Cut(h, "/")
+		if !strings.EqualFold(pu.Hostname(), host) {
+			continue
+		}
+		if prefix == "" || strings.HasPrefix(strings.TrimPrefix(pu.Path, "/"), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func abs(base *url.URL, su string) string {
 	if su == "" {
 		return ""
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yosssi/gohtml"
+)
+
+func TestAgendaHTMLContentID(t *testing.T) {
+	agenda := func(page string) (string, string) {
+		t.Helper()
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		html, id, err := agendaHTML(doc.Find(".AgendaItems"), "https://example.com/agenda", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return html, id
+	}
+
+	html, id := agenda(`<div class="AgendaItems"><p onclick="x()">Call to order</p><script>track()</script></div>`)
+	if strings.Contains(html, "script") || strings.Contains(html, "onclick") {
+		t.Errorf("stored HTML isn't sanitized: %q", html)
+	}
+	if want := agendaContentID(prettyHTML("", `<p onclick="x()">Call to order</p><script>track()</script>`)); id != want {
+		t.Errorf("content ID = %v, want %v hashed from the unsanitized HTML", id, want)
+	}
+
+	// A change only in markup sanitizing removes still changes the page, so
+	// the ID changes even though the stored HTML doesn't.
+	html2, id2 := agenda(`<div class="AgendaItems"><p onclick="y()">Call to order</p><script>track()</script></div>`)
+	if html2 != html || id2 == id {
+		t.Errorf("got html equal=%v id equal=%v, want html equal and id different", html2 == html, id2 == id)
+	}
+
+	if html, id := agenda(`<div class="Other"></div>`); html != "" || id != "" {
+		t.Errorf("missing content gave html=%q id=%q, want neither", html, id)
+	}
+}
+
+func TestCanonicalizeMeetingURL(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://www.halifax.ca/city-hall/regional-council/march-1-2024-regional-council", "regional-council/march-1-2024-regional-council"},
+		{"https://www.halifax.ca/city-hallboards-committees-commissions/march-1-2024-audit", "boards-committees-commissions/march-1-2024-audit"},
+		{"http://legacycontent.halifax.ca/council/agendasc/documents/240301ca.pdf", "agendasc/documents/240301ca.pdf"},
+		{"https://www.halifax.ca/city-hall", ""},
+		{"", ""},
+		// Forms the old trimming left alone keep their IDs.
+		{"http://www.halifax.ca/city-hall/regional-council/march-1-2024", "http://www.halifax.ca/city-hall/regional-council/march-1-2024"},
+		{"https://halifax.ca/city-hall/regional-council/march-1-2024", "https://halifax.ca/city-hall/regional-council/march-1-2024"},
+		{"https://legacycontent.halifax.ca/council/agendasc/240301ca.pdf", "https://legacycontent.halifax.ca/council/agendasc/240301ca.pdf"},
+		{"https://example.com/agenda", "https://example.com/agenda"},
+	}
+	for _, tt := range tests {
+		if got := canonicalizeMeetingURL(tt.url); got != tt.want {
+			t.Errorf("canonicalizeMeetingURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestMeetingID(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	afternoon := time.Date(2024, 3, 1, 13, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		url  string
+		date time.Time
+		want string
+	}{
+		{"meeting page", "https://www.halifax.ca/city-hall/regional-council/march-1-2024-regional-council", afternoon, "regional-council/march-1-2024-regional-council"},
+		{"section page", "https://www.halifax.ca/city-hall/boards-committees-commissions", afternoon, "boards-committees-commissions/regional-council-2024-03-01-1330"},
+		{"section page missing slash", "https://www.halifax.ca/city-hallboards-committees-commissions", afternoon, "boards-committees-commissions/regional-council-2024-03-01-1330"},
+		{"empty url", "", afternoon, "meeting/regional-council-2024-03-01-1330"},
+		{"date without time", "https://www.halifax.ca/city-hall/boards-committees-commissions", day, "boards-committees-commissions/regional-council-2024-03-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := meetingID(tt.url, "Regional Council", tt.date); got != tt.want {
+				t.Errorf("meetingID(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+
+	// Section page rows for different meetings on the same day get
+	// different IDs.
+	section := "https://www.halifax.ca/city-hall/boards-committees-commissions"
+	seen := make(map[string]bool)
+	for _, r := range []struct {
+		typ  string
+		date time.Time
+	}{
+		{"Regional Council", afternoon},
+		{"Regional Council", afternoon.Add(5 * time.Hour)},
+		{"Audit and Finance Standing Committee", afternoon},
+		{"Regional Council", day},
+	} {
+		id := meetingID(section, r.typ, r.date)
+		if seen[id] {
+			t.Errorf("meetingID(%q, %q, %v) = %q collides", section, r.typ, r.date, id)
+		}
+		seen[id] = true
+	}
+}
+
+// TestMarkdownFixtures converts each testdata/markdown/*.html agenda and
+// compares it with the .md file next to it.
+func TestMarkdownFixtures(t *testing.T) {
+	files, err := filepath.Glob("testdata/markdown/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures")
+	}
+	for _, fn := range files {
+		name := strings.TrimSuffix(filepath.Base(fn), ".html")
+		t.Run(name, func(t *testing.T) {
+			in, err := os.ReadFile(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(fn, ".html") + ".md")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := markdown(string(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != strings.TrimSuffix(string(want), "\n") {
+				t.Errorf("markdown:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPrettyHTMLFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		html      string
+		panics    bool
+		formatted bool
+	}{
+		{"well formed", "<div><p>Call to order</p></div>", false, true},
+		// gohtml parses the b tags in the CDATA section and drops its end.
+		{"text differs", "<div><svg><![CDATA[<b>Notes</b>]]></svg></div>", false, false},
+		{"panics", "<div><p>Call to order</div></p>", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.panics {
+				formatHTML = func(string) string { panic("malformed") }
+				t.Cleanup(func() { formatHTML = gohtml.Format })
+			}
+			got := prettyHTML("https://example.com/agenda", tt.html)
+			if tt.formatted {
+				if got != gohtml.Format(tt.html) {
+					t.Errorf("prettyHTML(%q) = %q, want it formatted", tt.html, got)
+				}
+				return
+			}
+			if got != tt.html {
+				t.Errorf("prettyHTML(%q) = %q, want it unformatted", tt.html, got)
+			}
+		})
+	}
+}
+
+func TestCheckMeetingDate(t *testing.T) {
+	min := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	max := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		date     time.Time
+		min, max time.Time
+		wantErr  bool
+	}{
+		{"in range", time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), min, max, false},
+		{"on min", min, min, max, false},
+		{"on max", max, min, max, false},
+		{"before min", time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), min, max, true},
+		{"after max", max.Add(time.Minute), min, max, true},
+		{"year misparsed as 0001", time.Date(1, 3, 1, 0, 0, 0, 0, time.UTC), min, max, true},
+		{"year misparsed as 2204", time.Date(2204, 3, 1, 0, 0, 0, 0, time.UTC), min, max, true},
+		{"no bounds", time.Date(2204, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{}, time.Time{}, false},
+		{"no min", time.Date(1, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{}, max, false},
+		{"no max", time.Date(2204, 3, 1, 0, 0, 0, 0, time.UTC), min, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkMeetingDate(tt.date, tt.min, tt.max); (err != nil) != tt.wantErr {
+				t.Errorf("checkMeetingDate(%v) = %v, want error %v", tt.date, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	tests := []struct {
+		u     string
+		hosts []string
+		want  bool
+	}{
+		{"https://www.halifax.ca/media/12345", defaultContentHosts, true},
+		{"http://www.halifax.ca/media/12345", defaultContentHosts, false},
+		{"https://www.halifax.ca/city-hall/agenda", defaultContentHosts, false},
+		{"https://CDN.halifax.ca/docs/report.pdf", defaultContentHosts, true},
+		{"ftp://cdn.halifax.ca/docs/report.pdf", defaultContentHosts, false},
+		{"http://legacycontent.halifax.ca/council/report.pdf", []string{"http://legacycontent.halifax.ca/council"}, true},
+		{"https://legacycontent.halifax.ca/council/report.pdf", []string{"http://legacycontent.halifax.ca/council"}, false},
+	}
+	for _, tt := range tests {
+		if got := hostAllowed(tt.u, tt.hosts); got != tt.want {
+			t.Errorf("hostAllowed(%q, %q) = %v, want %v", tt.u, tt.hosts, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"database/sql"
@@ -8,11 +9,17 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jxskiss/base62"
@@ -20,122 +27,396 @@ import (
 )
 
 type content struct {
-	id    string
-	title string
-	text  string
+	id         string
+	title      string
+	text       string
+	ocrSkipped bool   // text is empty because OCR was needed but -no-ocr was set
+	method     string // how text was extracted, one of the extract constants, or "" if it wasn't
 }
 
-func processExternalContentURLs(ctx context.Context, db *sql.DB, limiter *rate.Limiter, args []string) error {
-	if err := checkPDF(); err != nil {
+// How content text was extracted, stored as external_content.method.
+const (
+	extractTextLayer = "text"  // a PDF's text layer
+	extractOCR       = "ocr"   // OCR of PDF pages, for some or all of the text
+	extractPlain     = "plain" // a text/plain file as is
+)
+
+func processExternalContentURLs(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if err := checkPDF(cfg.noOCR); err != nil {
 		return err
 	}
 
-	urls, err := unfetchedURLs(ctx, db)
+	log.Println("fetching up to", cfg.urlBatch, "external content urls within", cfg.timeout)
+
+	if cfg.resetCursor {
+		if _, err := db.Exec("delete from cursors where name=?", urlsCursor); err != nil {
+			return fmt.Errorf("reset cursor: %w", err)
+		}
+	}
+	var after int64
+	if err := db.QueryRow("select position from cursors where name=?", urlsCursor).Scan(&after); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select cursor: %w", err)
+	}
+
+	var staleBefore time.Time
+	if cfg.refetchAfter > 0 {
+		staleBefore = time.Now().Add(-cfg.refetchAfter)
+	}
+	urls, err := urlsToFetch(ctx, db, cfg.urlBatch, after, staleBefore)
 	if err != nil {
-		return fmt.Errorf("unfetched urls: %w", err)
+		return fmt.Errorf("urls to fetch: %w", err)
 	}
 
 	log.Println("need", len(urls), "external content urls")
+	cfg.summary.add("needed", len(urls))
 
-	start := time.Now()
+	budget := time.After(cfg.timeout)
 
-	for i, u := range urls {
-		if err := limiter.Wait(ctx); err != nil {
+	// Up to -concurrency URLs are fetched and extracted at once, each
+	// waiting on limiter first, with their writes serialized by
+	// cfg.dbWriter. URLs finish out of order, so the cursor only moves past
+	// a URL once it and all those before it in urls are done, and a run
+	// that stops partway doesn't skip any.
+	// Two URLs can have the same content, so its extraction, which shares
+	// OCR checkpoints and the cache, is only done by one at a time.
+	cfg.extracting = &keyLocks{}
+	var (
+		mu        sync.Mutex
+		done      = make([]bool, len(urls))
+		next      int // index of the first URL not done
+		completed int
+		firstErr  error
+	)
+	process := func(i int) error {
+		u := urls[i].url
+		if err := cfg.limiterWait.wait(ctx, limiter); err != nil {
 			return fmt.Errorf("process %v: %w", u, err)
 		}
-		if err := processURL(ctx, db, u); err != nil {
-			return fmt.Errorf("process %v: %w", u, err)
+		perr := processURL(ctx, db, cfg, u, false)
+
+		mu.Lock()
+		defer mu.Unlock()
+		// The cursor moves past u even if processing failed, so a URL
+		// that keeps failing doesn't hold up the rest of the backlog.
+		// It's tried again once the cursor wraps around.
+		done[i] = true
+		prev := next
+		for next < len(urls) && done[next] {
+			next++
 		}
+		if next > prev {
+			err := cfg.dbWriter.do(func(db *sql.DB) error {
+				_, err := db.Exec("insert into cursors (name, position) values (?, ?) on conflict (name) do update set position=excluded.position", urlsCursor, urls[next-1].rowid)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("save cursor: %w", err)
+			}
+		}
+		if perr != nil {
+			return fmt.Errorf("process %v: %w", u, perr)
+		}
+		cfg.summary.add("processed", 1)
 
-		if (i+1)%10 == 0 {
-			log.Println("completed", i+1, "/", len(urls), "external content urls")
+		completed++
+		if completed%10 == 0 {
+			log.Println("completed", completed, "/", len(urls), "external content urls")
 		}
+		return nil
+	}
 
-		if time.Since(start) > 30*time.Minute {
-			log.Println("completed", i+1, "/", len(urls), "external content urls and ran out of time")
-			return nil
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(cfg.concurrency, len(urls)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := process(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	// URLs stop being handed out once one fails or the time budget is
+	// spent. Those already started are finished.
+	var outOfTime bool
+dispatch:
+	for i := range urls {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		select {
+		case work <- i:
+		case <-budget:
+			outOfTime = true
+			break dispatch
 		}
 	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if outOfTime {
+		log.Println("completed", completed, "/", len(urls), "external content urls and ran out of time")
+		return nil
+	}
 
 	log.Println("completed", len(urls), "external content urls")
 
 	return nil
 }
 
-func unfetchedURLs(ctx context.Context, db *sql.DB) ([]string, error) {
-	rows, err := db.Query("select url from external_content_urls where fetched is null limit 500")
-	if err != nil {
-		return nil, fmt.Errorf("select: %w", err)
+// keyLocks holds a lock per key, such as a content ID, so goroutines working
+// on the same key take turns.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu      sync.Mutex
+	waiters int // holding or waiting for mu
+}
+
+// lock waits until no one else holds key and returns a func that releases
+// it. k may be nil, in which case there's nothing to wait for.
+func (k *keyLocks) lock(key string) (unlock func()) {
+	if k == nil {
+		return func() {}
+	}
+	k.mu.Lock()
+	l := k.locks[key]
+	if l == nil {
+		if k.locks == nil {
+			k.locks = make(map[string]*keyLock)
+		}
+		l = &keyLock{}
+		k.locks[key] = l
 	}
-	defer rows.Close()
+	l.waiters++
+	k.mu.Unlock()
 
-	var urls []string
-	for rows.Next() {
-		var u string
-		if err := rows.Scan(&u); err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if l.waiters--; l.waiters == 0 {
+			delete(k.locks, key)
 		}
-		urls = append(urls, u)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("select: %w", err)
+}
+
+// urlsCursor names the cursors row tracking how far through
+// external_content_urls the urls action has got, by rowid.
+const urlsCursor = "urls"
+
+type queuedURL struct {
+	rowid int64
+	url   string
+}
+
+// urlsToFetch returns up to limit URLs to fetch in the order they were
+// added, starting after rowid after: those never fetched, and if
+// staleBefore isn't zero, those with stored content last fetched before it.
+// Stale URLs are fetched conditionally by processURL. If there aren't enough
+// after after, it wraps around to the start, so URLs passed over earlier are
+// tried again.
+func urlsToFetch(ctx context.Context, db *sql.DB, limit int, after int64, staleBefore time.Time) ([]queuedURL, error) {
+	const need = "(fetched is null or (external_content_id is not null and fetched < ?))"
+	var urls []queuedURL
+	for _, q := range []string{
+		"select rowid, url from external_content_urls where " + need + " and rowid > ? order by rowid limit ?",
+		"select rowid, url from external_content_urls where " + need + " and rowid <= ? order by rowid limit ?",
+	} {
+		rows, err := db.QueryContext(ctx, q, newTimeValue(&staleBefore), after, limit-len(urls))
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+		for rows.Next() {
+			var u queuedURL
+			if err := rows.Scan(&u.rowid, &u.url); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan: %w", err)
+			}
+			urls = append(urls, u)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+		if len(urls) >= limit || after == 0 {
+			break
+		}
 	}
 	return urls, nil
 }
 
-func processURL(ctx context.Context, db *sql.DB, u string) error {
+// processURL fetches u and saves its content. Content that's already stored
+// is only extracted again if reprocess is set.
+func processURL(ctx context.Context, db *sql.DB, cfg config, u string, reprocess bool) error {
 	now := time.Now()
 
+	var (
+		prevID, linkText, prevETag sql.NullString
+		prevLastModified           time.Time
+	)
+	if err := db.QueryRow("select external_content_id, link_text, etag, last_modified from external_content_urls where url=?", u).Scan(&prevID, &linkText, &prevETag, newTimeValue(&prevLastModified)); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select previous content ID: %w", err)
+	}
+
+	// The fetch is conditional if there's stored content to fall back on
+	// and it isn't being extracted again.
+	var prev Validators
+	if prevID.Valid && !reprocess {
+		prev.ETag = prevETag.String
+		if !prevLastModified.IsZero() {
+			prev.LastModified = prevLastModified.UTC().Format(http.TimeFormat)
+		}
+	}
+
 	saveErr := func(ferr error) error {
-		_, err := db.Exec("update external_content_urls set fetched=?, error=? where url=?", newTimeValue(&now), ferr.Error(), u)
-		if err != nil {
-			return fmt.Errorf("update external_content_urls: %w", err)
+		cfg.summary.add("errored", 1)
+		var kind sql.NullString
+		if k := errorKind(ferr); k != "" {
+			kind = sql.NullString{String: k, Valid: true}
 		}
-		return nil
+		// Interrupted OCR stays unfetched so the next urls run picks it
+		// up and resumes from its checkpoints.
+		var fetched any = newTimeValue(&now)
+		if kind.String == errorKindInterrupted {
+			fetched = nil
+		}
+		return cfg.dbWriter.do(func(db *sql.DB) error {
+			_, err := db.Exec("update external_content_urls set fetched=?, error=?, error_kind=? where url=?", fetched, ferr.Error(), kind, u)
+			if err != nil {
+				return fmt.Errorf("update external_content_urls: %w", err)
+			}
+			return nil
+		})
 	}
 
-	uc, ferr := fetchURLContent(ctx, u)
-	if ferr != nil {
-		if err := saveErr(ferr); err != nil {
-			return fmt.Errorf("save error: %w", err)
+	var (
+		uc     urlContent
+		cached bool
+	)
+	// Only reprocessing reads stored content from the cache. Otherwise,
+	// such as for a stale URL, the conditional fetch is what finds out
+	// whether it changed, and fetchURLContent leaves already cached
+	// content in place.
+	if cfg.contentCache != "" && prevID.Valid && reprocess {
+		var err error
+		uc, cached, err = cachedURLContent(db, cfg.contentCache, u, prevID.String)
+		if err != nil {
+			return fmt.Errorf("content cache: %w", err)
 		}
-		return nil
+	}
+	if !cached {
+		var ferr error
+		uc, ferr = fetchURLContent(ctx, cfg.httpClient, defaultRetrier, cfg.fetchTimeout, u, cfg.contentCache, prev)
+		if ferr != nil {
+			if err := saveErr(ferr); err != nil {
+				return fmt.Errorf("save error: %w", err)
+			}
+			detail := map[string]any{"error": ferr.Error()}
+			if uc.status != 0 {
+				detail["status"] = uc.status
+			}
+			recordEvent(cfg, eventURL, u, detail)
+			return nil
+		}
+	}
+	if uc.notModified {
+		recordEvent(cfg, eventURL, u, map[string]any{"not_modified": true, "status": uc.status})
+		cfg.summary.add("not_modified", 1)
+		return cfg.dbWriter.do(func(db *sql.DB) error {
+			if _, err := db.Exec("update external_content_urls set fetched=?, error=null, error_kind=null where url=?", newTimeValue(&now), u); err != nil {
+				return fmt.Errorf("update external_content_urls: %w", err)
+			}
+			return nil
+		})
+	}
+	detail := map[string]any{"bytes": uc.size, "content_type": uc.contentType, "cached": cached}
+	if !cached {
+		detail["status"] = uc.status
+	}
+	recordEvent(cfg, eventURL, u, detail)
+	if cached {
+		cfg.summary.add("cached", 1)
+	} else {
+		cfg.summary.add("fetched", 1)
 	}
 	defer uc.f.Close()
-	defer os.Remove(uc.f.Name())
+	if !uc.cached {
+		defer os.Remove(uc.f.Name())
+	}
 
 	c := content{id: uc.contentID}
 
+	// Held through saving, so another URL with the same content finds it
+	// stored rather than extracting it again.
+	defer cfg.extracting.lock(c.id)()
+
 	exists, err := contentExists(ctx, db, c.id)
 	if err != nil {
 		return fmt.Errorf("checking content ID %v existence: %w", c.id, err)
 	}
 
-	if !exists {
-		switch uc.contentType {
-		case "application/pdf":
-			p, perr := processPDF(ctx, uc.f)
-			if err != nil {
-				if err := saveErr(perr); err != nil {
-					return fmt.Errorf("save error: %w", err)
-				}
-				return nil
+	if !exists || reprocess {
+		var xerr error
+		c, xerr = extractContent(ctx, cfg, u, uc, linkText.String)
+		if xerr != nil {
+			recordContentParseFailure(cfg, u, uc, xerr)
+			if err := saveErr(xerr); err != nil {
+				return fmt.Errorf("save error: %w", err)
 			}
-			c.title = p.title
-			c.text = p.text
+			return nil
 		}
 	}
 
+	err = cfg.dbWriter.do(func(db *sql.DB) error {
+		return saveURLContent(ctx, db, u, uc, c, !exists, reprocess, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	if prevID.Valid && prevID.String != c.id {
+		log.Printf("external content changed url=%v previous=%v current=%v", u, prevID.String, c.id)
+		cfg.summary.add("changed", 1)
+		cfg.notifier.notify(ctx, contentChange{Kind: changeContent, URL: u, PreviousContentID: prevID.String, ContentID: c.id, Observed: now})
+	}
+	return nil
+}
+
+// saveURLContent records the fetch of u, saving c if it's new or replacing it
+// when reprocessing.
+func saveURLContent(ctx context.Context, db *sql.DB, u string, uc urlContent, c content, isNew, reprocess bool, now time.Time) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	if !exists {
+	if isNew {
 		if err := saveContent(ctx, tx, c); err != nil {
 			return fmt.Errorf("saving content ID %v: %w", c.id, err)
 		}
+	} else if reprocess {
+		if err := replaceContent(ctx, tx, c); err != nil {
+			return fmt.Errorf("replacing content ID %v: %w", c.id, err)
+		}
 	}
 
 	var etag sql.NullString
@@ -144,7 +425,7 @@ func processURL(ctx context.Context, db *sql.DB, u string) error {
 		etag.String = uc.etag
 	}
 
-	if _, err := tx.Exec("update external_content_urls set fetched=?, content_type=?, size=?, last_modified=?, etag=?, error=?, external_content_id=? where url=?", newTimeValue(&now), uc.contentType, uc.size, newTimeValue(&uc.lastModified), etag, nil, c.id, u); err != nil {
+	if _, err := tx.Exec("update external_content_urls set fetched=?, content_type=?, size=?, last_modified=?, etag=?, error=?, error_kind=?, external_content_id=? where url=?", newTimeValue(&now), uc.contentType, uc.size, newTimeValue(&uc.lastModified), etag, nil, nil, c.id, u); err != nil {
 		return fmt.Errorf("update external_content_urls: %w", err)
 	}
 
@@ -154,6 +435,80 @@ func processURL(ctx context.Context, db *sql.DB, u string) error {
 	return nil
 }
 
+// contentChange is sent when a URL's content ID differs from the one
+// previously stored for it.
+type contentChange struct {
+	Kind              string    `json:"kind"`
+	URL               string    `json:"url"`
+	PreviousContentID string    `json:"previous_content_id"`
+	ContentID         string    `json:"content_id"`
+	Observed          time.Time `json:"observed"`
+}
+
+// extractContent extracts the title and text of uc. If -content-cache is set,
+// OCR progress is checkpointed there so an interrupted run can resume.
+func extractContent(ctx context.Context, cfg config, u string, uc urlContent, linkText string) (content, error) {
+	c := content{id: uc.contentID}
+	mediaType, _, _ := mime.ParseMediaType(uc.contentType)
+	switch {
+	case uc.contentType == "application/pdf":
+		ocr := ocrOptions{skip: cfg.noOCR}
+		if cfg.contentCache != "" {
+			ocr.dir = filepath.Join(cfg.contentCache, uc.contentID+".ocr")
+		}
+		p, err := processPDF(ctx, uc.f, ocr)
+		if err != nil {
+			return content{}, err
+		}
+		c.title = p.title
+		c.text = p.text
+		c.ocrSkipped = p.ocrSkipped
+		c.method = p.method
+	case mediaType == "text/plain":
+		text, err := plainText(uc.f)
+		if err != nil {
+			return content{}, err
+		}
+		c.text = text
+		c.method = extractPlain
+	}
+	c.title = titleFor(uc, c.title, linkText)
+	if c.title == "" && mediaType == "text/plain" {
+		c.title = urlFileName(u)
+	}
+	return c, nil
+}
+
+// maxPlainText is the most of a text/plain file stored as its text.
+const maxPlainText = 1 << 20
+
+// plainText reads up to maxPlainText bytes of f from the start, replacing
+// invalid UTF-8.
+func plainText(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("read text: %w", err)
+	}
+	b, err := io.ReadAll(io.LimitReader(f, maxPlainText))
+	if err != nil {
+		return "", fmt.Errorf("read text: %w", err)
+	}
+	return strings.ToValidUTF8(string(b), "\uFFFD"), nil
+}
+
+// urlFileName returns the last segment of u's path, unescaped, or "" if it
+// has none.
+func urlFileName(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(pu.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
 func contentExists(ctx context.Context, db *sql.DB, id string) (bool, error) {
 	var exists bool
 	if err := db.QueryRow("select 1 from external_content where id=?", id).Scan(&exists); err != nil && !errors.Is(err, sql.ErrNoRows) {
@@ -163,9 +518,16 @@ func contentExists(ctx context.Context, db *sql.DB, id string) (bool, error) {
 }
 
 func saveContent(ctx context.Context, tx *sql.Tx, c content) error {
-	if _, err := tx.Exec("insert into external_content (id, title, text) values (?, ?, ?) on conflict do nothing", c.id, c.title, c.text); err != nil {
+	res, err := tx.Exec("insert into external_content (id, title, text, ocr_skipped, method) values (?, ?, ?, ?, ?) on conflict do nothing", c.id, c.title, c.text, c.ocrSkipped, extractMethod(c))
+	if err != nil {
 		return fmt.Errorf("insert content: %w", err)
 	}
+	// Another URL with the same content may have saved it since we checked.
+	if ra, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("insert content: %w", err)
+	} else if ra == 0 {
+		return nil
+	}
 
 	const sq = `insert into external_content_search (rowid, title, text) values ((select rowid from external_content where id=?), ?, ?)`
 	if _, err := tx.Exec(sq, c.id, c.title, c.text); err != nil {
@@ -181,25 +543,38 @@ type urlContent struct {
 	size         int64
 	lastModified time.Time
 	etag         string
+	status       int  // the response's HTTP status, or 0 if cached
+	cached       bool // f is in the content cache and shouldn't be removed
+	notModified  bool // a conditional fetch found it unchanged; there's no f
 }
 
-func fetchURLContent(ctx context.Context, u string) (_ urlContent, rerr error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+// fetchURLContent downloads u with client to a temporary file, retrying
+// transient failures with retry and giving up after timeout. If cacheDir is
+// set, the content is also saved there. The request is conditional on any
+// validators in prev, and if the server says the content is unchanged only
+// notModified and status are set. status is also set along with an error for
+// an unexpected status.
+func fetchURLContent(ctx context.Context, client *http.Client, retry Retrier, timeout time.Duration, u, cacheDir string, prev Validators) (_ urlContent, rerr error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return urlContent{}, fmt.Errorf("new request: %w", err)
 	}
+	prev.setHeaders(req)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := retry.Do(client, req)
 	if err != nil {
 		return urlContent{}, fmt.Errorf("fetch: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && (prev.ETag != "" || prev.LastModified != "") {
+		return urlContent{status: resp.StatusCode, notModified: true}, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return urlContent{}, fmt.Errorf("fetch: bad status %v", resp.StatusCode)
+		return urlContent{status: resp.StatusCode}, fmt.Errorf("fetch: bad status %v", resp.StatusCode)
 	}
 
 	f, err := os.CreateTemp("", "fetchURLContent")
@@ -211,6 +586,7 @@ func fetchURLContent(ctx context.Context, u string) (_ urlContent, rerr error) {
 			return
 		}
 		f.Close()
+		os.Remove(f.Name())
 	}()
 
 	contentSum := sha256.New224()
@@ -234,56 +610,242 @@ func fetchURLContent(ctx context.Context, u string) (_ urlContent, rerr error) {
 		}
 	}
 
-	return urlContent{f, resp.Header.Get("Content-Type"), contentID, size, lastModified, resp.Header.Get("ETag")}, nil
+	if cacheDir != "" {
+		if err := cacheContent(cacheDir, contentID, f); err != nil {
+			return urlContent{}, fmt.Errorf("caching content: %w", err)
+		}
+	}
+
+	return urlContent{f, resp.Header.Get("Content-Type"), contentID, size, lastModified, resp.Header.Get("ETag"), resp.StatusCode, false, false}, nil
 }
 
 type pdf struct {
-	title string
-	text  string
+	title      string
+	text       string
+	ocrSkipped bool
+	method     string // extractTextLayer or extractOCR, or "" if there's no text
 }
 
-func checkPDF() error {
-	for _, cmd := range []string{"pdfinfo", "pdftotext", "pdftoppm", "tesseract"} {
+// ocrOptions controls OCR of PDFs without a text layer.
+type ocrOptions struct {
+	skip bool   // leave the text empty instead
+	dir  string // if set, per-page results are checkpointed here
+}
+
+// checkPDF checks the tools needed to extract PDF text are installed. The
+// OCR tools aren't needed if noOCR is set. It also logs their versions,
+// warning about any older than known to work, since output can change
+// between versions.
+func checkPDF(noOCR bool) error {
+	cmds := []string{"pdfinfo", "pdftotext", "pdfdetach"}
+	if !noOCR {
+		cmds = append(cmds, "pdftoppm", "tesseract")
+	}
+	for _, cmd := range cmds {
 		_, err := exec.LookPath(cmd)
 		if err != nil {
 			return fmt.Errorf("missing %v, need to install poppler-utils and tesseract-ocr on ubuntu or poppler and tesseract via homebrew: %w", cmd, err)
 		}
 	}
+
+	tools := []pdfTool{{"poppler", []string{"pdfinfo", "-v"}, "22.02.0"}}
+	if !noOCR {
+		tools = append(tools, pdfTool{"tesseract", []string{"tesseract", "--version"}, "4.1.1"})
+	}
+	for _, t := range tools {
+		v, err := t.version()
+		switch {
+		case err != nil:
+			log.Printf("warning: couldn't determine %v version: %v", t.name, err)
+		case compareVersions(v, t.minVersion) < 0:
+			log.Printf("warning: %v version=%v is older than %v, the oldest known to work; extracted text or titles may differ", t.name, v, t.minVersion)
+		default:
+			log.Printf("%v version=%v", t.name, v)
+		}
+	}
 	return nil
 }
 
-func processPDF(ctx context.Context, f *os.File) (pdf, error) {
+// pdfTool is an external tool whose version checkPDF logs. The minimum
+// versions are those in Ubuntu 22.04, the oldest extraction is known to
+// work with.
+type pdfTool struct {
+	name       string
+	versionCmd []string
+	minVersion string
+}
+
+var toolVersionRE = regexp.MustCompile(`\d+(?:\.\d+)+`)
+
+// version runs the tool's version command and returns the first dotted
+// version number in its output. pdfinfo -v writes to stderr and older
+// versions exit non-zero, so both outputs are read and the exit status is
+// ignored if there's a version.
+func (t pdfTool) version() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, t.versionCmd[0], t.versionCmd[1:]...).CombinedOutput()
+	if v := toolVersionRE.FindString(string(out)); v != "" {
+		return v, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no version in output %q", strings.TrimSpace(string(out)))
+}
+
+// compareVersions compares dotted version numbers like 22.02.0 numerically
+// by component, with missing components as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var an, bn int
+		if i < len(as) {
+			an, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			bn, _ = strconv.Atoi(bs[i])
+		}
+		if c := cmp.Compare(an, bn); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Error kinds recorded in external_content_urls.error_kind.
+const (
+	errorKindEncrypted = "encrypted"
+	errorKindCorrupt   = "corrupt"
+	// errorKindInterrupted is OCR cut short after checkpointing, which
+	// the next urls run resumes.
+	errorKindInterrupted = "interrupted"
+)
+
+// contentError is an extraction error with a known kind.
+type contentError struct {
+	kind string
+	err  error
+}
+
+func (e contentError) Error() string { return e.err.Error() }
+func (e contentError) Unwrap() error { return e.err }
+
+// errorKind returns the kind of err if it's a contentError, or "".
+func errorKind(err error) string {
+	var ce contentError
+	if errors.As(err, &ce) {
+		return ce.kind
+	}
+	return ""
+}
+
+// pdfToolError wraps an error from running a poppler tool, classifying it
+// using the tool's stderr.
+func pdfToolError(tool string, err error) error {
+	var stderr string
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		stderr = strings.TrimSpace(string(ee.Stderr))
+	}
+	err = fmt.Errorf("%v: %w: %v", tool, err, stderr)
+
+	switch {
+	case strings.Contains(stderr, "Incorrect password"):
+		return contentError{errorKindEncrypted, err}
+	case strings.Contains(stderr, "May not be a PDF file"),
+		strings.Contains(stderr, "Couldn't find trailer dictionary"),
+		strings.Contains(stderr, "Couldn't read xref table"),
+		strings.Contains(stderr, "Syntax Error"):
+		return contentError{errorKindCorrupt, err}
+	}
+	return err
+}
+
+// processPDF extracts the title and text of the PDF in f. If the PDF looks
+// corrupt and qpdf is available, it tries again with a copy repaired by qpdf.
+// If ocr.dir is set, per-page OCR results are kept there until the whole
+// document is done, so a rerun after an interruption only OCRs the pages that
+// are missing.
+func processPDF(ctx context.Context, f *os.File, ocr ocrOptions) (pdf, error) {
+	p, err := processPDFFile(ctx, f.Name(), ocr)
+	if errorKind(err) != errorKindCorrupt {
+		return p, err
+	}
+	if _, lerr := exec.LookPath("qpdf"); lerr != nil {
+		return pdf{}, err
+	}
+
+	repaired, rerr := repairPDF(ctx, f.Name())
+	if rerr != nil {
+		log.Printf("repairing corrupt pdf: %v", rerr)
+		return pdf{}, err
+	}
+	defer os.Remove(repaired)
+
+	return processPDFFile(ctx, repaired, ocr)
+}
+
+func repairPDF(ctx context.Context, fn string) (string, error) {
+	out, err := os.CreateTemp("", "repairPDF")
+	if err != nil {
+		return "", fmt.Errorf("create temp: %w", err)
+	}
+	out.Close()
+
+	err = exec.CommandContext(ctx, "qpdf", fn, out.Name()).Run()
+	var ee *exec.ExitError
+	// qpdf exits 3 when it succeeded with warnings, which is expected when
+	// it had to repair something.
+	if err != nil && !(errors.As(err, &ee) && ee.ExitCode() == 3) {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("qpdf: %w", err)
+	}
+	return out.Name(), nil
+}
+
+func processPDFFile(ctx context.Context, fn string, ocr ocrOptions) (pdf, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	tc := exec.CommandContext(ctx, "pdfinfo", f.Name())
+	tc := exec.CommandContext(ctx, "pdfinfo", "-enc", "UTF-8", fn)
 	out, err := tc.Output()
 	if err != nil {
-		return pdf{}, fmt.Errorf("pdfinfo: %w", err)
+		return pdf{}, pdfToolError("pdfinfo", err)
 	}
+	title := pdfInfoTitle(string(out))
 
-	var title string
-	outs := string(out)
-	lines := strings.Split(outs, "\n")
-	for _, l := range lines {
-		if strings.HasPrefix(l, "Title:") {
-			title = l
-			break
-		}
+	tc = exec.CommandContext(ctx, "pdftotext", fn, "-")
+	out, err = tc.Output()
+	if err != nil {
+		return pdf{}, pdfToolError("pdftotext", err)
 	}
 
-	title = strings.TrimSpace(strings.TrimPrefix(title, "Title:"))
-	title = strings.TrimSpace(strings.TrimSuffix(title, "| Halifax.ca"))
+	text := strings.TrimSpace(string(out))
 
-	tc = exec.CommandContext(ctx, "pdftotext", f.Name(), "-")
-	out, err = tc.Output()
+	attached, err := portfolioText(ctx, fn, ocr)
 	if err != nil {
-		return pdf{}, fmt.Errorf("pdftotext: %w", err)
+		return pdf{}, err
+	}
+	if attached.text != "" || attached.ocrSkipped {
+		if ocr.dir != "" {
+			os.RemoveAll(ocr.dir)
+		}
+		method := attached.method
+		if method == "" && text != "" {
+			method = extractTextLayer
+		}
+		return pdf{title: title, text: strings.TrimSpace(text + "\n\n" + attached.text), ocrSkipped: attached.ocrSkipped, method: method}, nil
 	}
 
-	if text := strings.TrimSpace(string(out)); text != "" {
-		return pdf{title, text}, nil
+	if text != "" {
+		return pdf{title: title, text: text, method: extractTextLayer}, nil
 	}
+	if ocr.skip {
+		log.Printf("skipping ocr of pdf without text file=%v", fn)
+		return pdf{title: title, ocrSkipped: true}, nil
+	}
+	ocrDir := ocr.dir
 
 	td, err := os.MkdirTemp("", "processPDF")
 	if err != nil {
@@ -291,7 +853,7 @@ func processPDF(ctx context.Context, f *os.File) (pdf, error) {
 	}
 	defer os.RemoveAll(td)
 
-	tc = exec.CommandContext(ctx, "pdftoppm", "-png", f.Name(), filepath.Join(td, "page"))
+	tc = exec.CommandContext(ctx, "pdftoppm", "-png", fn, filepath.Join(td, "page"))
 	if err := tc.Run(); err != nil {
 		return pdf{}, fmt.Errorf("pdftoppm: %w", err)
 	}
@@ -301,18 +863,50 @@ func processPDF(ctx context.Context, f *os.File) (pdf, error) {
 		return pdf{}, fmt.Errorf("glob: %w", err)
 	}
 
+	if ocrDir != "" {
+		if err := os.MkdirAll(ocrDir, 0o755); err != nil {
+			return pdf{}, fmt.Errorf("mkdir ocr checkpoints: %w", err)
+		}
+	}
+
+	var resumed int
 	for _, pageFn := range pageFns {
+		textFn := pageFn + ".txt"
+		var checkpointFn string
+		if ocrDir != "" {
+			checkpointFn = filepath.Join(ocrDir, filepath.Base(textFn))
+			if err := copyFile(checkpointFn, textFn); err == nil {
+				resumed++
+				continue
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return pdf{}, fmt.Errorf("read ocr checkpoint: %w", err)
+			}
+		}
+
 		if err := exec.CommandContext(ctx, "tesseract", pageFn, pageFn).Run(); err != nil {
-			return pdf{}, fmt.Errorf("tesseract: %w", err)
+			err = fmt.Errorf("tesseract: %w", err)
+			if ctx.Err() != nil && ocrDir != "" {
+				return pdf{}, contentError{errorKindInterrupted, err}
+			}
+			return pdf{}, err
+		}
+
+		if checkpointFn != "" {
+			if err := copyFile(textFn, checkpointFn); err != nil {
+				return pdf{}, fmt.Errorf("write ocr checkpoint: %w", err)
+			}
 		}
 	}
+	if resumed > 0 {
+		log.Printf("resumed ocr pages=%v of %v from %v", resumed, len(pageFns), ocrDir)
+	}
 
 	textFns, err := filepath.Glob(filepath.Join(td, "page*.txt"))
 	if err != nil {
 		return pdf{}, fmt.Errorf("glob: %w", err)
 	}
 
-	var text string
+	text = ""
 	for _, textFn := range textFns {
 		b, err := os.ReadFile(textFn)
 		if err != nil {
@@ -320,5 +914,136 @@ func processPDF(ctx context.Context, f *os.File) (pdf, error) {
 		}
 		text += string(b) + "\n"
 	}
-	return pdf{title, strings.TrimSpace(text)}, nil
+
+	// Checkpoints are only for resuming; a later reprocess should OCR again.
+	if ocrDir != "" {
+		if err := os.RemoveAll(ocrDir); err != nil {
+			log.Printf("removing ocr checkpoints: %v", err)
+		}
+	}
+	text = strings.TrimSpace(text)
+	var method string
+	if text != "" {
+		method = extractOCR
+	}
+	return pdf{title: title, text: text, method: method}, nil
+}
+
+// pdfInfoTitle returns the title from pdfinfo output, without any
+// "| Halifax.ca" suffix. It allows for differences between versions: CRLF
+// line endings, leading space, the key's case and invalid UTF-8.
+func pdfInfoTitle(out string) string {
+	for _, l := range strings.Split(out, "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(l), ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "Title") {
+			continue
+		}
+		title := strings.TrimSpace(strings.ToValidUTF8(val, ""))
+		return strings.TrimSpace(strings.TrimSuffix(title, "| Halifax.ca"))
+	}
+	return ""
+}
+
+// portfolioText returns the text of PDFs embedded in fn, as in a PDF
+// portfolio, separated by blank lines. The text is "" if there are none, or
+// if they can't be listed. The container of a portfolio usually has little
+// text of its own.
+func portfolioText(ctx context.Context, fn string, ocr ocrOptions) (pdf, error) {
+	out, err := exec.CommandContext(ctx, "pdfdetach", "-list", fn).Output()
+	if err != nil {
+		// pdftotext already read fn, so its own text is still good.
+		log.Printf("listing pdf attachments file=%v: %v; using the pdf's own text", fn, pdfToolError("pdfdetach", err))
+		return pdf{}, nil
+	}
+	// Output starts like "2 embedded files".
+	count, _, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	if n, _ := strconv.Atoi(count); n == 0 {
+		return pdf{}, nil
+	}
+
+	td, err := os.MkdirTemp("", "portfolioText")
+	if err != nil {
+		return pdf{}, fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer os.RemoveAll(td)
+
+	if err := exec.CommandContext(ctx, "pdfdetach", "-saveall", "-o", td, fn).Run(); err != nil {
+		return pdf{}, pdfToolError("pdfdetach", err)
+	}
+
+	entries, err := os.ReadDir(td)
+	if err != nil {
+		return pdf{}, fmt.Errorf("read attachments: %w", err)
+	}
+
+	var (
+		texts      []string
+		ocrSkipped bool
+		method     string
+	)
+	for i, e := range entries {
+		afn := filepath.Join(td, e.Name())
+		if ok, err := isPDFFile(afn); err != nil {
+			return pdf{}, fmt.Errorf("attachment %v: %w", e.Name(), err)
+		} else if !ok {
+			continue
+		}
+
+		child := ocrOptions{skip: ocr.skip}
+		if ocr.dir != "" {
+			child.dir = filepath.Join(ocr.dir, "attachment"+strconv.Itoa(i))
+		}
+		p, err := processPDFFile(ctx, afn, child)
+		if err != nil {
+			return pdf{}, fmt.Errorf("attachment %v: %w", e.Name(), err)
+		}
+		if p.text != "" {
+			texts = append(texts, p.text)
+		}
+		ocrSkipped = ocrSkipped || p.ocrSkipped
+		if method == "" || p.method == extractOCR {
+			method = p.method
+		}
+	}
+	return pdf{text: strings.Join(texts, "\n\n"), ocrSkipped: ocrSkipped, method: method}, nil
+}
+
+func isPDFFile(fn string) (bool, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	b := make([]byte, 5)
+	n, err := io.ReadFull(f, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return string(b[:n]) == "%PDF-", nil
+}
+
+// extractMethod returns c.method for storing, NULL if text wasn't
+// extracted.
+func extractMethod(c content) sql.NullString {
+	return sql.NullString{String: c.method, Valid: c.method != ""}
+}
+
+// replaceContent updates the title and text of existing content, along with
+// its search index entry.
+func replaceContent(ctx context.Context, tx *sql.Tx, c content) error {
+	const dq = `insert into external_content_search (external_content_search, rowid, title, text) select 'delete', rowid, title, text from external_content where id=?`
+	if _, err := tx.Exec(dq, c.id); err != nil {
+		return fmt.Errorf("delete content search: %w", err)
+	}
+
+	if _, err := tx.Exec("update external_content set title=?, text=?, ocr_skipped=?, method=? where id=?", c.title, c.text, c.ocrSkipped, extractMethod(c), c.id); err != nil {
+		return fmt.Errorf("update content: %w", err)
+	}
+
+	const sq = `insert into external_content_search (rowid, title, text) values ((select rowid from external_content where id=?), ?, ?)`
+	if _, err := tx.Exec(sq, c.id, c.title, c.text); err != nil {
+		return fmt.Errorf("insert content search: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jxskiss/base62"
+)
+
+func TestFetchURLContentConditional(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		etag            string // sent by the server, if any
+		prev            Validators
+		wantNotModified bool
+	}{
+		{
+			name:            "strong etag",
+			etag:            `"abc"`,
+			prev:            Validators{ETag: `"abc"`},
+			wantNotModified: true,
+		},
+		{
+			name:            "weak etag",
+			etag:            `W/"abc"`,
+			prev:            Validators{ETag: `W/"abc"`},
+			wantNotModified: true,
+		},
+		{
+			name:            "changed etag",
+			etag:            `"def"`,
+			prev:            Validators{ETag: `"abc"`},
+			wantNotModified: false,
+		},
+		{
+			name:            "no etag, last modified",
+			prev:            Validators{LastModified: modified.Format(http.TimeFormat)},
+			wantNotModified: true,
+		},
+		{
+			name:            "no etag, modified since",
+			prev:            Validators{LastModified: modified.Add(-time.Hour).Format(http.TimeFormat)},
+			wantNotModified: false,
+		},
+		{
+			name:            "no validators",
+			etag:            `"abc"`,
+			wantNotModified: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				http.ServeContent(w, r, "", modified, strings.NewReader("agenda attachment"))
+			}))
+			defer srv.Close()
+
+			uc, err := fetchURLContent(context.Background(), srv.Client(), Retrier{}, 10*time.Second, srv.URL, "", tt.prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uc.notModified != tt.wantNotModified {
+				t.Fatalf("notModified = %v, want %v", uc.notModified, tt.wantNotModified)
+			}
+			if uc.notModified {
+				if uc.f != nil || uc.contentID != "" {
+					t.Errorf("not modified content has f=%v contentID=%q, want neither", uc.f, uc.contentID)
+				}
+				return
+			}
+			defer os.Remove(uc.f.Name())
+			defer uc.f.Close()
+			if uc.size != int64(len("agenda attachment")) {
+				t.Errorf("size = %v, want %v", uc.size, len("agenda attachment"))
+			}
+		})
+	}
+}
+
+func TestFetchURLContentNotModifiedUnasked(t *testing.T) {
+	// A 304 to an unconditional request has nothing to fall back on.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	_, err := fetchURLContent(context.Background(), srv.Client(), Retrier{}, 10*time.Second, srv.URL, "", Validators{})
+	if err == nil {
+		t.Fatal("got no error for an unrequested 304")
+	}
+}
+
+func TestFetchURLContent(t *testing.T) {
+	const body = "%PDF-1.4 agenda attachment"
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		lastModified string // sent by the server, if any
+		want         time.Time
+	}{
+		{"last modified", modified.Format(http.TimeFormat), modified},
+		{"no last modified", "", time.Time{}},
+		{"bad last modified", "yesterday", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/pdf")
+				if tt.lastModified != "" {
+					w.Header().Set("Last-Modified", tt.lastModified)
+				}
+				io.WriteString(w, body)
+			}))
+			defer srv.Close()
+
+			cacheDir := t.TempDir()
+			uc, err := fetchURLContent(context.Background(), srv.Client(), Retrier{}, 10*time.Second, srv.URL, cacheDir, Validators{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(uc.f.Name())
+			defer uc.f.Close()
+
+			sum := sha256.Sum224([]byte(body))
+			if want := base62.EncodeToString(sum[:]); uc.contentID != want {
+				t.Errorf("contentID = %v, want %v", uc.contentID, want)
+			}
+			if uc.size != int64(len(body)) {
+				t.Errorf("size = %v, want %v", uc.size, len(body))
+			}
+			if uc.contentType != "application/pdf" {
+				t.Errorf("contentType = %q, want application/pdf", uc.contentType)
+			}
+			if !uc.lastModified.Equal(tt.want) {
+				t.Errorf("lastModified = %v, want %v", uc.lastModified, tt.want)
+			}
+
+			got, err := io.ReadAll(uc.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("file = %q, want %q", got, body)
+			}
+			cached, err := os.ReadFile(filepath.Join(cacheDir, uc.contentID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(cached) != body {
+				t.Errorf("cached = %q, want %q", cached, body)
+			}
+		})
+	}
+}
+
+func TestFetchURLContentBadStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	if _, err := fetchURLContent(context.Background(), srv.Client(), Retrier{}, 10*time.Second, srv.URL, "", Validators{}); err == nil {
+		t.Fatal("got no error for a 404")
+	}
+}
+
+// fakePDFTools puts shell scripts standing in for the poppler and tesseract
+// tools first in PATH. scripts replaces the defaults, which describe a one
+// page PDF without a text layer or attachments, by tool name.
+func fakePDFTools(t *testing.T, scripts map[string]string) {
+	t.Helper()
+	tools := map[string]string{
+		"pdfinfo":   `echo "Title: Agenda"`,
+		"pdftotext": `exit 0`,
+		"pdfdetach": `echo "0 embedded files"`,
+		"pdftoppm":  `touch "$3-1.png"`,
+		"tesseract": `echo "page text" > "$2.txt"`,
+	}
+	for name, script := range scripts {
+		tools[name] = script
+	}
+	dir := t.TempDir()
+	for name, script := range tools {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// slowSecondPage is a tesseract that OCRs page 1 and hangs on page 2.
+const slowSecondPage = `case "$1" in *-1.png) echo "page one" > "$2.txt" ;; *) exec sleep 60 ;; esac`
+
+func TestProcessPDFOCRResume(t *testing.T) {
+	fakePDFTools(t, map[string]string{
+		"pdftoppm":  `touch "$3-1.png" "$3-2.png"`,
+		"tesseract": slowSecondPage,
+	})
+	ocr := ocrOptions{dir: filepath.Join(t.TempDir(), "content.ocr")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := processPDFFile(ctx, "agenda.pdf", ocr)
+	if errorKind(err) != errorKindInterrupted {
+		t.Fatalf("got error %v kind %q, want kind %q", err, errorKind(err), errorKindInterrupted)
+	}
+	if _, err := os.Stat(filepath.Join(ocr.dir, "page-1.png.txt")); err != nil {
+		t.Fatalf("page 1 wasn't checkpointed: %v", err)
+	}
+
+	// Page 1 comes from its checkpoint, not this tesseract.
+	fakePDFTools(t, map[string]string{
+		"pdftoppm":  `touch "$3-1.png" "$3-2.png"`,
+		"tesseract": `case "$1" in *-1.png) exit 1 ;; *) echo "page two" > "$2.txt" ;; esac`,
+	})
+	p, err := processPDFFile(context.Background(), "agenda.pdf", ocr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.text != "page one\n\npage two" {
+		t.Errorf("text = %q, want both pages", p.text)
+	}
+	if _, err := os.Stat(ocr.dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkpoints left after finishing: %v", err)
+	}
+}
+
+func TestProcessURLOCRInterrupted(t *testing.T) {
+	fakePDFTools(t, map[string]string{
+		"pdftoppm":  `touch "$3-1.png" "$3-2.png"`,
+		"tesseract": slowSecondPage,
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		io.WriteString(w, "%PDF-1.4 scanned")
+	}))
+	defer srv.Close()
+
+	db := testDB(t)
+	if _, err := db.Exec("insert into external_content_urls (url) values (?)", srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{httpClient: srv.Client(), dbWriter: newDBWriter(db), contentCache: t.TempDir(), fetchTimeout: 10 * time.Second}
+	defer cfg.dbWriter.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := processURL(ctx, db, cfg, srv.URL, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		fetched sql.NullString
+		kind    string
+	)
+	if err := db.QueryRow("select fetched, error_kind from external_content_urls where url=?", srv.URL).Scan(&fetched, &kind); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Valid || kind != errorKindInterrupted {
+		t.Errorf("got fetched=%v error_kind=%q, want fetched null and kind %q", fetched, kind, errorKindInterrupted)
+	}
+	queued, err := urlsToFetch(context.Background(), db, 10, 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].url != srv.URL {
+		t.Errorf("queued = %+v, want the interrupted URL", queued)
+	}
+}
+
+func TestProcessPDFAttachmentListFails(t *testing.T) {
+	fakePDFTools(t, map[string]string{
+		"pdftotext": `echo "Regional Council agenda"`,
+		"pdfdetach": `echo "Syntax Error: bad xref" >&2; exit 1`,
+	})
+	p, err := processPDFFile(context.Background(), "agenda.pdf", ocrOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.text != "Regional Council agenda" || p.method != extractTextLayer {
+		t.Errorf("got text %q method %q, want the container's text layer", p.text, p.method)
+	}
+}
+
+func TestProcessURLSameContentConcurrently(t *testing.T) {
+	// Each OCR run is counted, and is slow enough for both URLs to be in
+	// flight at once.
+	runs := filepath.Join(t.TempDir(), "runs")
+	fakePDFTools(t, map[string]string{
+		"tesseract": `echo run >> "` + runs + `"; sleep 0.2; echo "page text" > "$2.txt"`,
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		io.WriteString(w, "%PDF-1.4 scanned")
+	}))
+	defer srv.Close()
+
+	db := testDB(t)
+	urls := []string{srv.URL + "/a.pdf", srv.URL + "/b.pdf"}
+	for _, u := range urls {
+		if _, err := db.Exec("insert into external_content_urls (url) values (?)", u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config{httpClient: srv.Client(), dbWriter: newDBWriter(db), contentCache: t.TempDir(), fetchTimeout: 10 * time.Second, extracting: &keyLocks{}}
+	defer cfg.dbWriter.Close()
+
+	errs := make(chan error, len(urls))
+	for _, u := range urls {
+		go func() { errs <- processURL(context.Background(), db, cfg, u, false) }()
+	}
+	for range urls {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "run"); n != 1 {
+		t.Errorf("content was OCRed %v times, want once", n)
+	}
+	var n int
+	if err := db.QueryRow("select count(distinct external_content_id) from external_content_urls where fetched is not null and error is null").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("URLs have %v distinct content IDs, want both the same one", n)
+	}
+}
+
+func TestProcessURLEventStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html><body><p>Staff report</p></body></html>")
+	}))
+	defer srv.Close()
+
+	db := testDB(t)
+	cfg := config{httpClient: srv.Client(), dbWriter: newDBWriter(db), fetchTimeout: 10 * time.Second, events: true}
+	defer cfg.dbWriter.Close()
+
+	for path, want := range map[string]float64{"/report": http.StatusOK, "/gone": http.StatusNotFound} {
+		u := srv.URL + path
+		if _, err := db.Exec("insert into external_content_urls (url) values (?)", u); err != nil {
+			t.Fatal(err)
+		}
+		if err := processURL(context.Background(), db, cfg, u, false); err != nil {
+			t.Fatal(err)
+		}
+
+		var detail string
+		if err := db.QueryRow("select detail from events where kind=? and subject=?", eventURL, u).Scan(&detail); err != nil {
+			t.Fatal(err)
+		}
+		var d map[string]any
+		if err := json.Unmarshal([]byte(detail), &d); err != nil {
+			t.Fatal(err)
+		}
+		if d["status"] != want {
+			t.Errorf("%v: event detail %v, want status %v", path, detail, want)
+		}
+	}
+}
+
+func TestProcessURLStaleWithCache(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		body, etag  = "Staff report, first draft", `"v1"`
+		ifNoneMatch []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	db := testDB(t)
+	if _, err := db.Exec("insert into external_content_urls (url) values (?)", srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{httpClient: srv.Client(), dbWriter: newDBWriter(db), contentCache: t.TempDir(), fetchTimeout: 10 * time.Second}
+	defer cfg.dbWriter.Close()
+	if err := processURL(context.Background(), db, cfg, srv.URL, false); err != nil {
+		t.Fatal(err)
+	}
+	var first string
+	if err := db.QueryRow("select external_content_id from external_content_urls where url=?", srv.URL).Scan(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	body, etag = "Staff report, final", `"v2"`
+	mu.Unlock()
+	queued, err := urlsToFetch(context.Background(), db, 10, 0, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 {
+		t.Fatalf("queued = %+v, want the stale URL", queued)
+	}
+	if err := processURL(context.Background(), db, cfg, queued[0].url, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"", `"v1"`}; !slices.Equal(ifNoneMatch, want) {
+		t.Errorf("If-None-Match sent = %q, want %q", ifNoneMatch, want)
+	}
+	var second string
+	if err := db.QueryRow("select external_content_id from external_content_urls where url=?", srv.URL).Scan(&second); err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Errorf("content ID unchanged after the server sent new content")
+	}
+}
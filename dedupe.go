@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/time/rate"
+)
+
+// dedupeContent finds external content whose text is the same once
+// normalized, such as two OCR runs of one scan that differ only in spacing
+// or punctuation, and reports how much collapsing each group into one row
+// would save. With -apply it repoints each group's URLs to the row with the
+// longest text and removes the others along with their search entries.
+func dedupeContent(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	type member struct {
+		id  string
+		len int
+	}
+	groups := make(map[[sha256.Size]byte][]member)
+
+	rows, err := db.QueryContext(ctx, "select id, text from external_content where coalesce(text, '') != '' order by id")
+	if err != nil {
+		return fmt.Errorf("dedupe-content: select: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return fmt.Errorf("dedupe-content: scan: %w", err)
+		}
+		norm := normalizeContentText(text)
+		if norm == "" {
+			continue
+		}
+		k := sha256.Sum256([]byte(norm))
+		groups[k] = append(groups[k], member{id, len(text)})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("dedupe-content: select: %w", err)
+	}
+	rows.Close()
+
+	var dupes [][]member
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+		sort.Slice(g, func(i, j int) bool {
+			if g[i].len != g[j].len {
+				return g[i].len > g[j].len
+			}
+			return g[i].id < g[j].id
+		})
+		dupes = append(dupes, g)
+	}
+	sort.Slice(dupes, func(i, j int) bool { return dupes[i][0].id < dupes[j][0].id })
+
+	var removed, savedBytes int
+	for _, g := range dupes {
+		var ids []string
+		for _, m := range g[1:] {
+			ids = append(ids, m.id)
+			savedBytes += m.len
+		}
+		removed += len(ids)
+		log.Printf("duplicate content canonical=%v duplicates=%v", g[0].id, strings.Join(ids, ","))
+	}
+
+	if !cfg.apply {
+		log.Printf("dedupe-content: would collapse groups=%v removing rows=%v text bytes=%v; run with -apply to do it", len(dupes), removed, savedBytes)
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("dedupe-content: begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, g := range dupes {
+		canonical := g[0].id
+		for _, m := range g[1:] {
+			if _, err := tx.Exec("update external_content_urls set external_content_id=? where external_content_id=?", canonical, m.id); err != nil {
+				return fmt.Errorf("dedupe-content: repoint %v: %w", m.id, err)
+			}
+			const dq = `insert into external_content_search (external_content_search, rowid, title, text) select 'delete', rowid, title, text from external_content where id=?`
+			if _, err := tx.Exec(dq, m.id); err != nil {
+				return fmt.Errorf("dedupe-content: content %v search: %w", m.id, err)
+			}
+			if _, err := tx.Exec("delete from external_content where id=?", m.id); err != nil {
+				return fmt.Errorf("dedupe-content: content %v: %w", m.id, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("dedupe-content: commit: %w", err)
+	}
+
+	log.Printf("dedupe-content: collapsed groups=%v removed rows=%v text bytes=%v", len(dupes), removed, savedBytes)
+	cfg.summary.add("collapsed", len(dupes))
+	cfg.summary.add("removed", removed)
+	return nil
+}
+
+// normalizeContentText lowercases text and keeps only its letters and
+// digits, so text differing only in spacing, line breaks or punctuation
+// normalizes the same.
+func normalizeContentText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+}
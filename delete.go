@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"golang.org/x/time/rate"
+)
+
+// deleteMeeting removes the meeting named by the first argument along with
+// its versions and content URL associations. Agenda content, external content
+// URLs and external content are only removed when no other meeting still
+// references them.
+func deleteMeeting(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("delete-meeting: need exactly one meeting id")
+	}
+	id := args[0]
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("delete-meeting: begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow("select count(*) from meetings where id=?", id).Scan(&n); err != nil {
+		return fmt.Errorf("delete-meeting: select meeting: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("delete-meeting: no meeting %v", id)
+	}
+
+	contentIDs, err := queryStrings(tx, `select agenda_content_id from meetings where id=?1 and agenda_content_id is not null
+		union select agenda_content_id from meeting_versions where meeting_id=?1 and agenda_content_id is not null
+		union select agenda_content_id from meeting_external_content_urls where meeting_id=?1 and agenda_content_id is not null
+		union select agenda_content_id from meeting_agendas where meeting_id=?1 and agenda_content_id is not null
+		union select agenda_content_id from meeting_public_hearings where meeting_id=?1 and agenda_content_id is not null`, id)
+	if err != nil {
+		return fmt.Errorf("delete-meeting: agenda content: %w", err)
+	}
+	urls, err := queryStrings(tx, "select distinct external_content_url from meeting_external_content_urls where meeting_id=?", id)
+	if err != nil {
+		return fmt.Errorf("delete-meeting: content urls: %w", err)
+	}
+
+	for _, q := range []string{
+		"update meetings set continuation_of=null where continuation_of=?",
+		"delete from meeting_external_content_urls where meeting_id=?",
+		"delete from meeting_public_hearings where meeting_id=?",
+		"delete from meeting_agendas where meeting_id=?",
+		"delete from meeting_versions where meeting_id=?",
+		"delete from meetings where id=?",
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return fmt.Errorf("delete-meeting: %w", err)
+		}
+	}
+
+	var removedContent, removedURLs int
+	for _, cid := range contentIDs {
+		var refs int
+		const rq = `select (select count(*) from meetings where agenda_content_id=?1)
+			+ (select count(*) from meeting_versions where agenda_content_id=?1)
+			+ (select count(*) from meeting_external_content_urls where agenda_content_id=?1)
+			+ (select count(*) from meeting_agendas where agenda_content_id=?1)
+			+ (select count(*) from meeting_public_hearings where agenda_content_id=?1)`
+		if err := tx.QueryRow(rq, cid).Scan(&refs); err != nil {
+			return fmt.Errorf("delete-meeting: agenda content %v refs: %w", cid, err)
+		}
+		if refs > 0 {
+			continue
+		}
+		for _, q := range []string{
+			`insert into meeting_agenda_content_search (meeting_agenda_content_search, rowid, text) select 'delete', rowid, text from meeting_agenda_content where id=?`,
+			"delete from meeting_agenda_items where agenda_content_id=?",
+			"delete from meeting_agenda_content where id=?",
+		} {
+			if _, err := tx.Exec(q, cid); err != nil {
+				return fmt.Errorf("delete-meeting: agenda content %v: %w", cid, err)
+			}
+		}
+		removedContent++
+	}
+
+	for _, u := range urls {
+		var refs int
+		if err := tx.QueryRow("select count(*) from meeting_external_content_urls where external_content_url=?", u).Scan(&refs); err != nil {
+			return fmt.Errorf("delete-meeting: url %v refs: %w", u, err)
+		}
+		if refs > 0 {
+			continue
+		}
+
+		var ecID sql.NullString
+		if err := tx.QueryRow("select external_content_id from external_content_urls where url=?", u).Scan(&ecID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("delete-meeting: url %v: %w", u, err)
+		}
+		if _, err := tx.Exec("delete from external_content_urls where url=?", u); err != nil {
+			return fmt.Errorf("delete-meeting: url %v: %w", u, err)
+		}
+		removedURLs++
+
+		if !ecID.Valid {
+			continue
+		}
+		if err := tx.QueryRow("select count(*) from external_content_urls where external_content_id=?", ecID.String).Scan(&refs); err != nil {
+			return fmt.Errorf("delete-meeting: content %v refs: %w", ecID.String, err)
+		}
+		if refs > 0 {
+			continue
+		}
+		const dq = `insert into external_content_search (external_content_search, rowid, title, text) select 'delete', rowid, title, text from external_content where id=?`
+		if _, err := tx.Exec(dq, ecID.String); err != nil {
+			return fmt.Errorf("delete-meeting: content %v search: %w", ecID.String, err)
+		}
+		if _, err := tx.Exec("delete from external_content where id=?", ecID.String); err != nil {
+			return fmt.Errorf("delete-meeting: content %v: %w", ecID.String, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete-meeting: commit: %w", err)
+	}
+
+	log.Println("deleted meeting", id, "agenda contents", removedContent, "urls", removedURLs)
+	return nil
+}
+
+// querier is a *sql.DB or *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryStrings(db querier, q string, args ...any) ([]string, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+type dumpManifestEntry struct {
+	ID          string   `json:"id"`
+	File        string   `json:"file"`
+	Title       string   `json:"title"`
+	ContentType string   `json:"content_type"`
+	URLs        []string `json:"urls"`
+}
+
+// dumpContent writes the text of each external content row with text to
+// <id>.txt in -output-dir, plus a manifest.json listing each file's title and
+// source URLs. -content-type limits it to content fetched with that type.
+func dumpContent(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if cfg.outputDir == "" {
+		return errors.New("dump-content: need -output-dir")
+	}
+	if err := os.MkdirAll(cfg.outputDir, 0o755); err != nil {
+		return fmt.Errorf("dump-content: %w", err)
+	}
+
+	const q = `select c.id, coalesce(c.title, ''), c.text, coalesce(u.content_type, ''), u.url
+		from external_content c
+		join external_content_urls u on u.external_content_id=c.id
+		where coalesce(c.text, '') != '' and (?1 = '' or u.content_type=?1)
+		order by c.id, u.url`
+	rows, err := db.QueryContext(ctx, q, cfg.contentType)
+	if err != nil {
+		return fmt.Errorf("dump-content: select: %w", err)
+	}
+	defer rows.Close()
+
+	manifest := []dumpManifestEntry{}
+	for rows.Next() {
+		var (
+			e    dumpManifestEntry
+			text string
+			u    string
+		)
+		if err := rows.Scan(&e.ID, &e.Title, &text, &e.ContentType, &u); err != nil {
+			return fmt.Errorf("dump-content: scan: %w", err)
+		}
+
+		// Rows are ordered by id, so more URLs for the same content follow
+		// directly.
+		if n := len(manifest); n > 0 && manifest[n-1].ID == e.ID {
+			manifest[n-1].URLs = append(manifest[n-1].URLs, u)
+			continue
+		}
+
+		e.File = e.ID + ".txt"
+		e.URLs = []string{u}
+		if err := os.WriteFile(filepath.Join(cfg.outputDir, e.File), []byte(strings.TrimSpace(text)+"\n"), 0o644); err != nil {
+			return fmt.Errorf("dump-content: %w", err)
+		}
+		manifest = append(manifest, e)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("dump-content: select: %w", err)
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("dump-content: marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.outputDir, "manifest.json"), append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("dump-content: %w", err)
+	}
+
+	log.Println("dumped", len(manifest), "external contents to", cfg.outputDir)
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Event kinds recorded with -events.
+const (
+	eventListing = "listing" // subject is the listing source
+	eventMeeting = "meeting" // subject is the meeting ID
+	eventURL     = "url"     // subject is the URL
+)
+
+// recordEvent adds an event with detail as JSON to the events table if
+// -events is set. Failing to record one is only logged, so auditing never
+// stops a crawl.
+func recordEvent(cfg config, kind, subject string, detail map[string]any) {
+	if !cfg.events {
+		return
+	}
+	b, err := json.Marshal(detail)
+	if err != nil {
+		log.Printf("recording event kind=%v subject=%v: %v", kind, subject, err)
+		return
+	}
+	now := time.Now()
+	err = cfg.dbWriter.do(func(db *sql.DB) error {
+		if _, err := db.Exec("insert into events (at, kind, subject, detail) values (?, ?, ?, ?)", newTimeValue(&now), kind, subject, string(b)); err != nil {
+			return fmt.Errorf("insert events: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("recording event kind=%v subject=%v: %v", kind, subject, err)
+	}
+}
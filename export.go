@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ExportSchemaVersion is the version of the export format. It's bumped when
+// MeetingExport changes incompatibly.
+//
+// Version 2 changed agenda content_urls from strings to objects with fetch
+// state.
+const ExportSchemaVersion = 2
+
+// MeetingExport is a meeting as written by the export action and read by the
+// import action.
+type MeetingExport struct {
+	// SchemaVersion is only set on -jsonl lines, which have no envelope.
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	ID           string              `json:"id"`
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	SessionKind  string              `json:"session_kind"`
+	Date         string              `json:"date"` // YYYY-MM-DD
+	ScheduleNote string              `json:"schedule_note"`
+	LastObserved *time.Time          `json:"last_observed"`
+	URLs         MeetingExportURLs   `json:"urls"`
+	Agenda       MeetingExportAgenda `json:"agenda"`
+}
+
+type MeetingExportURLs struct {
+	Agenda  string `json:"agenda"`
+	Minutes string `json:"minutes"`
+	Video   string `json:"video"`
+}
+
+type MeetingExportAgenda struct {
+	ContentID string `json:"content_id"`
+	Text      string `json:"text"`
+	HTML      string `json:"html"`
+	// HTMLOmitted is set when the agenda has text but its HTML wasn't
+	// stored, as with -store-html=false.
+	HTMLOmitted bool                      `json:"html_omitted,omitempty"`
+	ContentURLs []MeetingExportContentURL `json:"content_urls"`
+}
+
+// Content URL fetch states.
+const (
+	ContentURLPending = "pending"
+	ContentURLOK      = "ok"
+	ContentURLError   = "error"
+)
+
+// MeetingExportContentURL is an external content URL linked from an agenda.
+// Title is only set when State is ContentURLOK.
+type MeetingExportContentURL struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+	Title string `json:"title,omitempty"`
+}
+
+// UnmarshalJSON also accepts a bare URL string, as written by schema
+// version 1.
+func (u *MeetingExportContentURL) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*u = MeetingExportContentURL{URL: s, State: ContentURLPending}
+		return nil
+	}
+	type plain MeetingExportContentURL
+	return json.Unmarshal(b, (*plain)(u))
+}
+
+// exportMeetings writes all meetings to stdout, as a JSON document by default
+// or as JSON Lines with -jsonl. Rows are streamed from the cursor so memory
+// use doesn't grow with the size of the database.
+func exportMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	const q = `select m.id, m.type, coalesce(m.name, ''), coalesce(m.session_kind, ''), m.starts, m.schedule_note, m.last_observed, m.agenda_url, m.minutes_url, m.video_url, coalesce(m.agenda_content_id, ''), coalesce(c.text, ''), coalesce(c.html, '') from meetings m left join meeting_agenda_content c on c.id=m.agenda_content_id order by m.starts, m.id`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("export: select: %w", err)
+	}
+	defer rows.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(w)
+
+	if !cfg.jsonl {
+		fmt.Fprintf(w, `{"schema_version":%d,"meetings":[`, ExportSchemaVersion)
+	}
+	var n int
+	for rows.Next() {
+		var (
+			m                    MeetingExport
+			starts, lastObserved time.Time
+		)
+		if err := rows.Scan(&m.ID, &m.Type, &m.Name, &m.SessionKind, newTimeValue(&starts), &m.ScheduleNote, newTimeValue(&lastObserved), &m.URLs.Agenda, &m.URLs.Minutes, &m.URLs.Video, &m.Agenda.ContentID, &m.Agenda.Text, &m.Agenda.HTML); err != nil {
+			return fmt.Errorf("export: scan: %w", err)
+		}
+		m.Date = starts.Format(dateFormat)
+		m.Agenda.HTMLOmitted = m.Agenda.HTML == "" && m.Agenda.Text != ""
+		if !lastObserved.IsZero() {
+			lastObserved = lastObserved.In(cfg.tz)
+			m.LastObserved = &lastObserved
+		}
+		if cfg.jsonl {
+			m.SchemaVersion = ExportSchemaVersion
+		}
+
+		m.Agenda.ContentURLs, err = meetingContentURLs(ctx, db, m.ID)
+		if err != nil {
+			return fmt.Errorf("export: %v content urls: %w", m.ID, err)
+		}
+
+		if !cfg.jsonl && n > 0 {
+			w.WriteString(",")
+		}
+		if err := enc.Encode(m); err != nil {
+			return fmt.Errorf("export: encode %v: %w", m.ID, err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("export: select: %w", err)
+	}
+	if !cfg.jsonl {
+		w.WriteString("]}\n")
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+// meetingContentURLs returns the external content URLs linked from any of
+// the meeting's current agendas, the main agenda's first and then each
+// revision's in order. A URL linked from more than one is only listed once.
+func meetingContentURLs(ctx context.Context, db *sql.DB, meetingID string) ([]MeetingExportContentURL, error) {
+	const q = `select mu.external_content_url, u.fetched is not null, u.error is not null, c.id is not null, coalesce(c.title, mu.title, '')
+		from meeting_external_content_urls mu
+		join ` + meetingAgendaRevisions + ` a on a.agenda_content_id=mu.agenda_content_id
+		left join external_content_urls u on u.url=mu.external_content_url
+		left join external_content c on c.id=u.external_content_id
+		where mu.meeting_id=?1
+		order by a.revision, mu.sequence is null, mu.sequence, mu.external_content_url`
+	rows, err := db.QueryContext(ctx, q, meetingID)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer rows.Close()
+
+	urls := []MeetingExportContentURL{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var (
+			u                     MeetingExportContentURL
+			fetched, failed, have bool
+		)
+		if err := rows.Scan(&u.URL, &fetched, &failed, &have, &u.Title); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		switch {
+		case failed:
+			u.State = ContentURLError
+		case fetched && have:
+			u.State = ContentURLOK
+		default:
+			u.State = ContentURLPending
+		}
+		if u.State != ContentURLOK {
+			u.Title = ""
+		}
+		if seen[u.URL] {
+			continue
+		}
+		seen[u.URL] = true
+		urls = append(urls, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return urls, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	// maxParseFailureExcerpt is the most of a response body kept in
+	// parse_failures.
+	maxParseFailureExcerpt = 64 << 10
+	// parseFailureRetention is how long parse_failures rows are kept.
+	parseFailureRetention = 30 * 24 * time.Hour
+)
+
+// recordAgendaParseFailure records err in parse_failures if
+// -store-parse-failures is set and err is a ParseError.
+func recordAgendaParseFailure(cfg config, u string, err error) {
+	var perr ParseError
+	if !errors.As(err, &perr) {
+		return
+	}
+	recordParseFailure(cfg, u, perr.Status, perr.Body, perr.Err)
+}
+
+// recordContentParseFailure records a failure to extract uc's content in
+// parse_failures if -store-parse-failures is set. Content read from
+// -content-cache has no status.
+func recordContentParseFailure(cfg config, u string, uc urlContent, perr error) {
+	if !cfg.parseFailures {
+		return
+	}
+	if _, err := uc.f.Seek(0, io.SeekStart); err != nil {
+		log.Printf("recording parse failure url=%v: %v", u, err)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(uc.f, maxParseFailureExcerpt))
+	if err != nil {
+		log.Printf("recording parse failure url=%v: %v", u, err)
+		return
+	}
+	recordParseFailure(cfg, u, uc.status, body, perr)
+}
+
+// recordParseFailure adds a row with the start of body to parse_failures if
+// -store-parse-failures is set, and removes rows older than
+// parseFailureRetention. status is stored as NULL if it's 0. Like events,
+// failing to record one is only logged.
+func recordParseFailure(cfg config, u string, status int, body []byte, perr error) {
+	if !cfg.parseFailures {
+		return
+	}
+	if len(body) > maxParseFailureExcerpt {
+		body = body[:maxParseFailureExcerpt]
+	}
+	var st sql.NullInt64
+	if status != 0 {
+		st = sql.NullInt64{Int64: int64(status), Valid: true}
+	}
+	now := time.Now()
+	cutoff := now.Add(-parseFailureRetention)
+	err := cfg.dbWriter.do(func(db *sql.DB) error {
+		// One transaction, so a retry after a busy commit can't leave a
+		// duplicate row behind.
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin tx: %w", err)
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("insert into parse_failures (url, observed, status, error, body_excerpt) values (?, ?, ?, ?, ?)", u, newTimeValue(&now), st, perr.Error(), body); err != nil {
+			return fmt.Errorf("insert parse_failures: %w", err)
+		}
+		if _, err := tx.Exec("delete from parse_failures where observed < ?", newTimeValue(&cutoff)); err != nil {
+			return fmt.Errorf("prune parse_failures: %w", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("recording parse failure url=%v: %v", u, err)
+	}
+}
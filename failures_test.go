@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecordParseFailurePruneFails(t *testing.T) {
+	db := testDB(t)
+	old := time.Now().Add(-2 * parseFailureRetention)
+	if _, err := db.Exec("insert into parse_failures (url, observed, error, body_excerpt) values (?, ?, ?, ?)", "https://example.com/old", newTimeValue(&old), "old", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("create trigger no_prune before delete on parse_failures begin select raise(abort, 'no pruning'); end"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{parseFailures: true, dbWriter: newDBWriter(db)}
+	recordParseFailure(cfg, "https://example.com/new", 200, []byte("<html>"), errors.New("no meetings table"))
+
+	var n int
+	if err := db.QueryRow("select count(*) from parse_failures where url=?", "https://example.com/new").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d rows for the new failure, want the insert rolled back with the failed prune", n)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// publicHearingHeadingRE matches an agenda section heading for public
+	// hearings, such as "Public Hearings" or "13. PUBLIC HEARING". It only
+	// matches the whole title, so items that merely mention a hearing
+	// don't.
+	publicHearingHeadingRE = regexp.MustCompile(`(?i)^public hearings?:?$`)
+	// agendaTextItemRE matches a numbered line in agenda text, like
+	// "13.1 Case 24567: Rezoning of 123 Main Street".
+	agendaTextItemRE = regexp.MustCompile(`^(\d+(?:\.\d+)*)\.?\s+(\S.*)$`)
+)
+
+// publicHearing is a subject heard at a public hearing.
+type publicHearing struct {
+	Number  string
+	Subject string
+}
+
+// publicHearings reports whether m is or includes a public hearing, from its
+// session kind or a public hearings section in its agenda, and returns the
+// items in that section. Matching is conservative: without a section
+// heading numbered like its items, no subjects are returned.
+func publicHearings(m Meeting, agenda MeetingAgenda) (bool, []publicHearing) {
+	items := agenda.Items
+	if len(items) == 0 {
+		items = agendaTextItems(agenda.ContentText)
+	}
+
+	isHearing := m.SessionKind == SessionPublicHearing
+	var hearings []publicHearing
+	for i, item := range items {
+		if !publicHearingHeadingRE.MatchString(item.Title) {
+			continue
+		}
+		isHearing = true
+		prefix := strings.TrimSuffix(item.Number, ".") + "."
+		if prefix == "." {
+			continue
+		}
+		for _, sub := range items[i+1:] {
+			if !strings.HasPrefix(sub.Number, prefix) {
+				break
+			}
+			hearings = append(hearings, publicHearing{sub.Number, sub.Title})
+		}
+	}
+	return isHearing, hearings
+}
+
+// agendaTextItems returns the numbered lines of agenda text, for agendas
+// without structured items. Markdown heading and emphasis markers are
+// dropped, and unnumbered lines are returned with no number so headings
+// like "Public Hearings" are still seen.
+func agendaTextItems(text string) []AgendaItem {
+	var items []AgendaItem
+	for _, l := range strings.Split(text, "\n") {
+		l = strings.Join(strings.Fields(strings.Trim(l, "#*_ \t\r")), " ")
+		if l == "" {
+			continue
+		}
+		if m := agendaTextItemRE.FindStringSubmatch(l); m != nil {
+			items = append(items, AgendaItem{Number: m[1], Title: strings.Trim(m[2], "*_ ")})
+			continue
+		}
+		items = append(items, AgendaItem{Title: l})
+	}
+	return items
+}
+
+// savePublicHearings records the public hearing subjects found in the
+// meeting's agenda with the given content ID.
+func savePublicHearings(tx *sql.Tx, meetingID, contentID string, hearings []publicHearing) error {
+	for i, h := range hearings {
+		const q = `insert into meeting_public_hearings (meeting_id, agenda_content_id, position, number, subject) values (?, ?, ?, ?, ?) on conflict do nothing`
+		if _, err := tx.Exec(q, meetingID, contentID, i, h.Number, h.Subject); err != nil {
+			return fmt.Errorf("insert meeting public hearing: %w", err)
+		}
+	}
+	return nil
+}
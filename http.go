@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+const defaultUserAgent = "halifax-meetings (+https://github.com/danp/halifax-meetings)"
+
+// newHTTPClient returns a client that identifies itself with userAgent, or
+// defaultUserAgent if it's empty, and from as the From header if set. It
+// keeps up to maxIdlePerHost idle connections to each host for idleTimeout
+// so a run's many requests to the same few hosts reuse them, and counts
+// connections in conns if it's not nil.
+func newHTTPClient(userAgent, from string, maxIdlePerHost int, idleTimeout time.Duration, conns *connStats) *http.Client {
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ForceAttemptHTTP2 = true
+	base.MaxIdleConnsPerHost = maxIdlePerHost
+	if base.MaxIdleConns < maxIdlePerHost {
+		base.MaxIdleConns = maxIdlePerHost
+	}
+	base.IdleConnTimeout = idleTimeout
+	return &http.Client{
+		Transport: headerTransport{
+			userAgent: userAgent,
+			from:      from,
+			base:      base,
+			conns:     conns,
+		},
+	}
+}
+
+type headerTransport struct {
+	userAgent string
+	from      string
+	base      http.RoundTripper
+	conns     *connStats
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if t.conns != nil {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{GotConn: t.conns.gotConn})
+	}
+	req = req.Clone(ctx)
+	req.Header.Set("User-Agent", t.userAgent)
+	if t.from != "" {
+		req.Header.Set("From", t.from)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && t.conns != nil && resp.ProtoMajor == 2 {
+		t.conns.http2.Add(1)
+	}
+	return resp, err
+}
+
+// connStats counts the connections requests got and how many responses came
+// over HTTP/2, to show whether connections are being reused.
+type connStats struct {
+	opened, reused, http2 atomic.Int64
+}
+
+func (s *connStats) gotConn(info httptrace.GotConnInfo) {
+	if info.Reused {
+		s.reused.Add(1)
+	} else {
+		s.opened.Add(1)
+	}
+}
+
+func (s *connStats) String() string {
+	return fmt.Sprintf("opened=%v reused=%v http2-responses=%v", s.opened.Load(), s.reused.Load(), s.http2.Load())
+}
+
+// httpClientOrDefault returns c, or http.DefaultClient if c is nil.
+func httpClientOrDefault(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
+
+// Retrier retries requests that fail in ways likely to be transient: a
+// connection error, 429 Too Many Requests or a 5xx status. The zero value
+// tries once.
+type Retrier struct {
+	// MaxAttempts is the most times a request is sent, including the
+	// first.
+	MaxAttempts int
+	// BaseDelay is about how long to wait before the second attempt. It
+	// doubles for each attempt after, and each wait is jittered by up to
+	// half.
+	BaseDelay time.Duration
+}
+
+// defaultRetrier is the Retrier used for fetches from halifax.ca, eScribe
+// and external content hosts.
+var defaultRetrier = Retrier{MaxAttempts: 3, BaseDelay: time.Second}
+
+// Do sends req with client, or http.DefaultClient if it's nil, until it gets
+// a response that isn't worth retrying or runs out of attempts, and returns
+// the last response or error. Waiting between attempts stops if req's
+// context is done. Request bodies are resent with req.GetBody.
+func (r Retrier) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := httpClientOrDefault(client).Do(req)
+
+		var reason string
+		switch {
+		case err != nil && ctx.Err() == nil:
+			reason = err.Error()
+		case err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500):
+			reason = resp.Status
+		}
+		if reason == "" || attempt >= r.MaxAttempts {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // so the connection can be reused
+			resp.Body.Close()
+		}
+
+		wait := r.BaseDelay << (attempt - 1)
+		wait = wait/2 + rand.N(wait/2+1)
+		log.Printf("retrying url=%v attempt=%v wait=%v: %v", req.URL, attempt, wait, reason)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// ErrBlocked is returned when a response looks like a bot challenge or block
+// page rather than the content we asked for.
+var ErrBlocked = errors.New("blocked")
+
+// blockMarkers are strings found in common CDN/WAF challenge and block pages.
+var blockMarkers = []string{
+	"cf-browser-verification",
+	"challenge-platform",
+	"cf-chl-",
+	"<title>Just a moment...</title>",
+	"Attention Required! | Cloudflare",
+	"_Incapsula_Resource",
+	"Request unsuccessful. Incapsula incident",
+	"The requested URL was rejected. Please consult with your administrator.",
+}
+
+// readUnblockedBody reads resp's body, returning an error wrapping ErrBlocked
+// if it looks like we were served a challenge or block page.
+func readUnblockedBody(resp *http.Response) ([]byte, error) {
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if err := checkBlocked(resp, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func checkBlocked(resp *http.Response, body []byte) error {
+	if resp.Header.Get("cf-mitigated") == "challenge" {
+		return fmt.Errorf("%w: url=%v status=%v cf-mitigated challenge", ErrBlocked, resp.Request.URL, resp.StatusCode)
+	}
+	for _, m := range blockMarkers {
+		if bytes.Contains(body, []byte(m)) {
+			return fmt.Errorf("%w: url=%v status=%v body contains %q", ErrBlocked, resp.Request.URL, resp.StatusCode, m)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetrierDo(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // returned in turn, the last repeating
+		wantStatus   int
+		wantRequests int32
+	}{
+		{"503 then 200", []int{503, 200}, 200, 2},
+		{"404 isn't retried", []int{404, 200}, 404, 1},
+		{"429 then 200", []int{429, 200}, 200, 2},
+		{"gives up after max attempts", []int{503}, 503, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				status := tt.statuses[min(n, len(tt.statuses))-1]
+				w.WriteHeader(status)
+				io.WriteString(w, http.StatusText(status))
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := Retrier{MaxAttempts: 3}.Do(srv.Client(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %v, want %v", resp.StatusCode, tt.wantStatus)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("requests = %v, want %v", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetrierDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		// Cancel once the retrier is waiting to try again.
+		time.AfterFunc(50*time.Millisecond, cancel)
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := Retrier{MaxAttempts: 3, BaseDelay: time.Hour}.Do(srv.Client(), req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("took %v, want it to stop waiting when canceled", d)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %v, want 1", n)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// importCounts tracks inserted and skipped (already present) rows by table.
+type importCounts map[string][2]int
+
+func (c importCounts) add(table string, res sql.Result) error {
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%v rows affected: %w", table, err)
+	}
+	v := c[table]
+	if ra > 0 {
+		v[0]++
+	} else {
+		v[1]++
+	}
+	c[table] = v
+	return nil
+}
+
+// importMeetings reads -jsonl export output from the file named by the first
+// argument, or stdin, and merges it into the database. Existing rows are left
+// as they are.
+func importMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	var r io.Reader = os.Stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	counts := make(importCounts)
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var m MeetingExport
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("import: decode: %w", err)
+		}
+		if m.SchemaVersion < 1 || m.SchemaVersion > ExportSchemaVersion {
+			return fmt.Errorf("import: meeting %v: unsupported schema version %v", m.ID, m.SchemaVersion)
+		}
+		if err := importMeeting(ctx, db, counts, m); err != nil {
+			return fmt.Errorf("import: meeting %v: %w", m.ID, err)
+		}
+	}
+
+	for _, table := range []string{"meetings", "meeting_versions", "meeting_agenda_content", "external_content_urls", "meeting_external_content_urls"} {
+		v := counts[table]
+		log.Printf("imported %v: inserted=%v skipped=%v", table, v[0], v[1])
+	}
+	return nil
+}
+
+func importMeeting(ctx context.Context, db *sql.DB, counts importCounts, m MeetingExport) error {
+	var lastObserved time.Time
+	if m.LastObserved != nil {
+		lastObserved = *m.LastObserved
+	}
+	var name sql.NullString
+	if m.Name != "" {
+		name = sql.NullString{String: m.Name, Valid: true}
+	}
+	starts, err := time.Parse(dateFormat, m.Date)
+	if err != nil {
+		return fmt.Errorf("bad date %q: %w", m.Date, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var agendaContentID sql.NullString
+	if m.Agenda.ContentID != "" {
+		agendaContentID = sql.NullString{String: m.Agenda.ContentID, Valid: true}
+
+		res, err := tx.Exec(`insert into meeting_agenda_content (id, text, html) values (?, ?, ?) on conflict (id) do nothing`, m.Agenda.ContentID, m.Agenda.Text, m.Agenda.HTML)
+		if err != nil {
+			return fmt.Errorf("insert meeting agenda content: %w", err)
+		}
+		if err := counts.add("meeting_agenda_content", res); err != nil {
+			return err
+		}
+		if ra, _ := res.RowsAffected(); ra > 0 {
+			const sq = `insert into meeting_agenda_content_search (rowid, text) values ((select rowid from meeting_agenda_content where id=?), ?)`
+			if _, err := tx.Exec(sq, m.Agenda.ContentID, m.Agenda.Text); err != nil {
+				return fmt.Errorf("insert meeting agenda content search: %w", err)
+			}
+		}
+	}
+
+	const mq = `insert into meetings (id, type, name, date, schedule_note, last_observed, agenda_url, minutes_url, video_url, agenda_content_id, session_kind, starts) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) on conflict (id) do nothing`
+	res, err := tx.Exec(mq, m.ID, m.Type, name, m.Date, m.ScheduleNote, newTimeValue(&lastObserved), m.URLs.Agenda, m.URLs.Minutes, m.URLs.Video, agendaContentID, m.SessionKind, newTimeValue(&starts))
+	if err != nil {
+		return fmt.Errorf("insert meeting: %w", err)
+	}
+	if err := counts.add("meetings", res); err != nil {
+		return err
+	}
+
+	const vq = `insert into meeting_versions (meeting_id, observed, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id) values (?, ?, ?, ?, ?, ?, ?) on conflict do nothing`
+	res, err = tx.Exec(vq, m.ID, newTimeValue(&lastObserved), m.ScheduleNote, m.URLs.Agenda, m.URLs.Minutes, m.URLs.Video, agendaContentID)
+	if err != nil {
+		return fmt.Errorf("insert meeting version: %w", err)
+	}
+	if err := counts.add("meeting_versions", res); err != nil {
+		return err
+	}
+
+	for _, cu := range m.Agenda.ContentURLs {
+		u := cu.URL
+		res, err := tx.Exec("insert into external_content_urls (url, added) values (?, ?) on conflict do nothing", u, newTimeValue(&lastObserved))
+		if err != nil {
+			return fmt.Errorf("insert external content URL %v: %w", u, err)
+		}
+		if err := counts.add("external_content_urls", res); err != nil {
+			return err
+		}
+
+		res, err = tx.Exec("insert into meeting_external_content_urls (meeting_id, agenda_content_id, external_content_url) values (?, ?, ?) on conflict do nothing", m.ID, agendaContentID, u)
+		if err != nil {
+			return fmt.Errorf("insert meeting external content URL %v: %w", u, err)
+		}
+		if err := counts.add("meeting_external_content_urls", res); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
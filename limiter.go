@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterWait tracks the total time spent waiting on a rate limiter.
+type limiterWait struct {
+	total atomic.Int64
+}
+
+// wait waits on l, adding the time spent to the total. w may be nil.
+func (w *limiterWait) wait(ctx context.Context, l *rate.Limiter) error {
+	start := time.Now()
+	err := l.Wait(ctx)
+	if w != nil {
+		w.total.Add(int64(time.Since(start)))
+	}
+	return err
+}
+
+func (w *limiterWait) Total() time.Duration {
+	if w == nil {
+		return 0
+	}
+	return time.Duration(w.total.Load())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// deadStatuses are the statuses check-links considers to mean a URL is gone
+// for good.
+var deadStatuses = []int{http.StatusNotFound, http.StatusGone}
+
+func checkLinks(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	rows, err := db.QueryContext(ctx, "select url from external_content_urls order by last_checked nulls first, url")
+	if err != nil {
+		return fmt.Errorf("check links: select: %w", err)
+	}
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return fmt.Errorf("check links: scan: %w", err)
+		}
+		urls = append(urls, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("check links: select: %w", err)
+	}
+
+	log.Println("checking", len(urls), "external content urls")
+
+	var dead int
+	for i, u := range urls {
+		if err := cfg.limiterWait.wait(ctx, limiter); err != nil {
+			return fmt.Errorf("check links: %w", err)
+		}
+
+		now := time.Now()
+		var status sql.NullInt64
+		code, err := checkLink(ctx, cfg.httpClient, u)
+		if err != nil {
+			log.Printf("checking url=%v: %v", u, err)
+			cfg.summary.add("errored", 1)
+		} else {
+			status.Valid = true
+			status.Int64 = int64(code)
+		}
+		if isDeadStatus(code) {
+			dead++
+			cfg.summary.add("dead", 1)
+			log.Printf("dead link url=%v status=%v", u, code)
+		}
+
+		if _, err := db.ExecContext(ctx, "update external_content_urls set last_checked=?, last_status=? where url=?", newTimeValue(&now), status, u); err != nil {
+			return fmt.Errorf("check links: update %v: %w", u, err)
+		}
+
+		cfg.summary.add("checked", 1)
+		if (i+1)%10 == 0 {
+			log.Println("checked", i+1, "/", len(urls), "external content urls")
+		}
+	}
+
+	log.Println("checked", len(urls), "external content urls,", dead, "dead")
+	return nil
+}
+
+func checkLink(ctx context.Context, client *http.Client, u string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	status, err := requestStatus(ctx, client, http.MethodHead, u)
+	if err != nil {
+		return 0, err
+	}
+	if status == http.StatusMethodNotAllowed {
+		return requestStatus(ctx, client, http.MethodGet, u)
+	}
+	return status, nil
+}
+
+func requestStatus(ctx context.Context, client *http.Client, method, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, fmt.Errorf("new request: %w", err)
+	}
+	resp, err := httpClientOrDefault(client).Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", method, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func isDeadStatus(status int) bool {
+	for _, s := range deadStatuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// deadStatusList returns deadStatuses as a comma-separated list for a SQL
+// in clause, so queries agree with check-links about which URLs are dead.
+func deadStatusList() string {
+	var ss []string
+	for _, s := range deadStatuses {
+		ss = append(ss, strconv.Itoa(s))
+	}
+	return strings.Join(ss, ", ")
+}
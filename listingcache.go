@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// dbListingCache is a ListingCache kept in the listing_cache table, with each
+// page stored as JSON.
+type dbListingCache struct {
+	db *sql.DB
+	w  *dbWriter
+}
+
+func (c dbListingCache) Get(pageURL string) (ListingPage, bool, error) {
+	var b string
+	if err := c.db.QueryRow("select page from listing_cache where url=?", pageURL).Scan(&b); errors.Is(err, sql.ErrNoRows) {
+		return ListingPage{}, false, nil
+	} else if err != nil {
+		return ListingPage{}, false, fmt.Errorf("select: %w", err)
+	}
+
+	var p ListingPage
+	if err := json.Unmarshal([]byte(b), &p); err != nil {
+		// Treat it as missing, it'll be replaced.
+		return ListingPage{}, false, nil
+	}
+	return p, true, nil
+}
+
+func (c dbListingCache) Put(pageURL string, p ListingPage) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	now := time.Now()
+	return c.w.do(func(db *sql.DB) error {
+		if _, err := db.Exec("insert into listing_cache (url, page, fetched) values (?, ?, ?) on conflict (url) do update set page=excluded.page, fetched=excluded.fetched", pageURL, string(b), newTimeValue(&now)); err != nil {
+			return fmt.Errorf("insert: %w", err)
+		}
+		return nil
+	})
+}
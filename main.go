@@ -4,57 +4,403 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata" // for -tz and meetingLocation on systems without zoneinfo
 
 	"golang.org/x/time/rate"
 	_ "modernc.org/sqlite"
 )
 
 func main() {
-	ctx := context.Background()
-
-	db, err := sql.Open("sqlite", "meetings.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	if err := initDB(db); err != nil {
-		log.Fatal(err)
-	}
+	// Cancel on the first interrupt so waits can stop cleanly, a second one
+	// exits as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	limiter := rate.NewLimiter(rate.Every(time.Second), 1)
 
 	fs := flag.NewFlagSet("halifax-meetings", flag.ExitOnError)
 	var only commaSeparatedString
 	fs.Var(&only, "only", "only run these comma-separated actions")
+	var cfg config
+	var webhookURLs, changelog string
+	var logChanges bool
+	fs.StringVar(&webhookURLs, "webhook-url", "", "comma-separated URLs to POST a JSON notification to when a meeting gets a new version or external content changes")
+	fs.BoolVar(&logChanges, "log-changes", false, "log a JSON notification when a meeting gets a new version or external content changes")
+	fs.StringVar(&changelog, "changelog", "", "if set, append a JSON notification line to this file when a meeting gets a new version or external content changes")
+	fs.StringVar(&cfg.outputDir, "output-dir", "", "directory to write the static site to for build-site, or content text to for dump-content")
+	fs.IntVar(&cfg.urlBatch, "url-batch", 500, "max external content urls to fetch per run")
+	fs.DurationVar(&cfg.refetchAfter, "refetch-after", 0, "also fetch external content urls with stored content last fetched longer ago than this, conditionally on their ETag and Last-Modified; 0 never fetches them again")
+	fs.BoolVar(&cfg.resetCursor, "reset-cursor", false, "start the urls action from the oldest unfetched url rather than where the last run left off")
+	fs.DurationVar(&cfg.timeout, "timeout", 30*time.Minute, "time budget for fetching external content urls")
+	fs.IntVar(&cfg.concurrency, "concurrency", 4, "external content urls to fetch and extract at once, still subject to the rate limit")
+	fs.DurationVar(&cfg.fetchTimeout, "fetch-timeout", time.Minute, "time limit for downloading each external content url")
+	fs.BoolVar(&cfg.jsonl, "jsonl", false, "export one JSON object per line instead of a single array")
+	fs.BoolVar(&cfg.agendaOnly, "agenda-only", false, "only store meetings' agendas: skip minutes and video URLs and don't queue agenda attachments or other documents for fetching")
+	fs.BoolVar(&cfg.noExtURLs, "no-external-urls", false, "don't queue agenda attachments or other meeting documents for fetching, for when the urls action is never run; minutes and video URLs are still stored")
+	fs.BoolVar(&cfg.storeHTML, "store-html", true, "store agenda HTML; if false only agenda text is kept, content ids are unaffected")
+	fs.StringVar(&cfg.urlsFile, "urls-file", "", "file of agenda URLs, one per line, for refetch-agendas")
+	fs.Var(&cfg.recompute, "recompute", "comma-separated derived fields for the recompute action to backfill: "+strings.Join(recomputerNames(), ", "))
+	fs.BoolVar(&cfg.noOCR, "no-ocr", false, "don't OCR PDFs without a text layer, storing empty text for them; tesseract and pdftoppm aren't needed")
+	fs.BoolVar(&cfg.events, "events", false, "record listings fetched, meetings processed and urls fetched in the events table")
+	fs.BoolVar(&cfg.parseFailures, "store-parse-failures", false, "keep the start of agenda pages and external content that fail to parse in the parse_failures table, for 30 days")
+	fs.BoolVar(&cfg.pretty, "pretty", true, "format stored agenda HTML; changing this changes agenda content ids, creating new versions")
+	fs.StringVar(&cfg.contentType, "content-type", "", "only reprocess or dump external content with this content type")
+	fs.IntVar(&cfg.limit, "limit", 0, "max items for reprocess-content, search, related and query, 0 for no limit, 10 for related or 1000 for query")
+	fs.StringVar(&cfg.contentCache, "content-cache", "", "if set, directory to keep downloaded external content in, named by content id, and to read it back from instead of fetching")
+	fs.IntVar(&cfg.minAgendaText, "min-agenda-text", 100, "agendas with less text than this many bytes aren't stored, as they're likely placeholders or a changed page layout; 0 to store all")
+	fs.IntVar(&cfg.maxMeetings, "max-meetings", 0, "max meetings to process per run, 0 for no limit")
+	fs.StringVar(&cfg.format, "format", "json", "output format for report, list, types, search, related, query, check-associations, remap-ids and changelog: json or csv, or text for changelog")
+	now := time.Now()
+	fs.Func("escribe-start", "start of the eScribe calendar window to list, a YYYY-MM-DD date or an offset from now like -2y, -6mo, -90d or -36h; defaults to -1y", relDateFlag(&cfg.escribeStart, now))
+	fs.Func("escribe-end", "end of the eScribe calendar window to list, like -escribe-start; defaults to +1y", relDateFlag(&cfg.escribeEnd, now))
+	fs.Func("min-meeting-date", "skip listed meetings dated before this, as misparsed; like -escribe-start, defaults to 2000-01-01", relDateFlag(&cfg.minDate, now))
+	fs.Func("max-meeting-date", "skip listed meetings dated after this, as misparsed; like -escribe-start, defaults to +3y or the day after the eScribe window listed, whichever is later, and can't be before the window's end", relDateFlag(&cfg.maxDate, now))
+	var tz string
+	fs.StringVar(&tz, "tz", "America/Halifax", "IANA time zone that list, export and stats render timestamps in, with their offset, and that changelog reads -since and -until dates in")
+	fs.BoolVar(&cfg.prefetchNext, "prefetch-next-window", false, "also list the year of eScribe meetings after -escribe-end, to catch meetings scheduled far ahead")
+	fs.BoolVar(&cfg.printView, "escribe-print-view", false, "parse eScribe agendas from their print view when it has the agenda, falling back to the agenda page; changing this changes agenda content ids, creating new versions")
+	fs.Func("since", "only include dates on or after this YYYY-MM-DD date; changelog also takes an RFC 3339 timestamp", dateFlag(&cfg.since))
+	fs.Func("until", "only include dates on or before this YYYY-MM-DD date; changelog also takes an RFC 3339 timestamp", dateFlag(&cfg.until))
+	fs.Func("strip-lines", "for reindex, a regular expression; lines of stored agenda and content text matching it, such as boilerplate, are permanently removed before rebuilding the search indexes; needs -apply, without it reindex only reports how many rows would change", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		cfg.stripLines = re
+		return nil
+	})
+	fs.BoolVar(&cfg.apply, "apply", false, "make dedupe-content, check-associations, remap-ids and reindex -strip-lines change the database; without it, they only report what they would do")
+	fs.Float64Var(&cfg.minChange, "min-agenda-change", 0, "fraction of an agenda's words, 0 to 1, that must change since the meeting's last version for a new version to be recorded and notified; 0 records every change")
+	fs.BoolVar(&cfg.needProgress, "require-progress", false, "make the meetings action fail if no meetings are listed and some source failed to list, which likely means listing is broken; sources whose listing is confirmed empty are fine")
+	fs.Func("exclude-types", "comma-separated meeting types for the meetings action to neither fetch nor save, matched ignoring case and spacing", func(s string) error {
+		cfg.excludeTypes = make(map[string]bool)
+		for _, t := range strings.Split(s, ",") {
+			if t = normalizeType(t); t != "" {
+				cfg.excludeTypes[t] = true
+			}
+		}
+		return nil
+	})
+	fs.Func("type-cadence", "comma-separated type=duration pairs; meetings of a listed type, matched ignoring case and spacing, aren't refetched more often than its duration", func(s string) error {
+		var d durationMap
+		if err := d.Set(s); err != nil {
+			return err
+		}
+		cfg.typeCadences = make(map[string]time.Duration)
+		for t, dur := range d.vals {
+			cfg.typeCadences[normalizeType(t)] = dur
+		}
+		return nil
+	})
+	fs.IntVar(&cfg.bundleTextLen, "bundle-text-len", 5000, "max bytes of agenda text per meeting to index in search-bundle, 0 for no limit")
+	fs.Func("content-hosts", "comma-separated hosts, optionally with a path prefix like www.halifax.ca/media, to collect https agenda attachment links from, or http ones with a scheme like http://example.com; defaults to "+strings.Join(defaultContentHosts, ",")+" for halifax.ca and all attachments for eScribe", func(s string) error {
+		cfg.contentHosts = []string{}
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				cfg.contentHosts = append(cfg.contentHosts, h)
+			}
+		}
+		return nil
+	})
+	var userAgent, from string
+	fs.StringVar(&userAgent, "user-agent", "", "User-Agent header to send, defaults to "+defaultUserAgent)
+	fs.StringVar(&from, "from", "", "if set, email address to send in the From header")
+	var maxIdlePerHost int
+	fs.IntVar(&maxIdlePerHost, "max-idle-conns-per-host", 8, "idle HTTP connections to keep open to each host for reuse")
+	var idleConnTimeout time.Duration
+	fs.DurationVar(&idleConnTimeout, "idle-conn-timeout", 90*time.Second, "how long an idle HTTP connection is kept open for reuse")
+	var checkStale durationMap
+	fs.Var(&checkStale, "check-stale", "comma-separated action=duration pairs; instead of running actions, exit non-zero if any listed action hasn't succeeded within its duration. meetings/halifax and meetings/escribe check the meetings action's sources")
+	var listActions bool
+	fs.BoolVar(&listActions, "list-actions", false, "print the available actions and exit")
+	var printConfig bool
+	fs.BoolVar(&printConfig, "print-config", false, "log a summary of the effective configuration at startup")
+	var actionDelay time.Duration
+	fs.DurationVar(&actionDelay, "action-delay", 0, "time to wait between actions, to spread load on the remote hosts")
+	var fast bool
+	fs.BoolVar(&fast, "fast", false, "use WAL journaling, synchronous=NORMAL, and a larger cache for write-heavy runs; a crash may lose the most recent commits")
 	fs.Parse(os.Args[1:])
+	conns := &connStats{}
+	cfg.httpClient = newHTTPClient(userAgent, from, maxIdlePerHost, idleConnTimeout, conns)
+	cfg.notifier = &notifier{}
+	for _, u := range strings.Split(webhookURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			cfg.notifier.sinks = append(cfg.notifier.sinks, webhookSink(cfg.httpClient, u))
+		}
+	}
+	if logChanges {
+		cfg.notifier.sinks = append(cfg.notifier.sinks, logSink())
+	}
+	if changelog != "" {
+		cfg.notifier.sinks = append(cfg.notifier.sinks, changelogSink(changelog))
+	}
+	if cfg.escribeStart.IsZero() {
+		cfg.escribeStart = now.AddDate(-1, 0, 0)
+	}
+	if cfg.escribeEnd.IsZero() {
+		cfg.escribeEnd = now.AddDate(1, 0, 0)
+	}
+	if !cfg.escribeStart.Before(cfg.escribeEnd) {
+		log.Fatalf("-escribe-start %v is not before -escribe-end %v", cfg.escribeStart.Format(dateFormat), cfg.escribeEnd.Format(dateFormat))
+	}
+	if cfg.minDate.IsZero() {
+		cfg.minDate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+	listedEnd := escribeListedEnd(cfg.escribeEnd, cfg.prefetchNext)
+	if cfg.maxDate.IsZero() {
+		cfg.maxDate = defaultMaxMeetingDate(now, listedEnd)
+	}
+	if cfg.maxDate.Before(listedEnd) {
+		log.Fatalf("-max-meeting-date %v is before %v, the end of the eScribe window listed, so meetings listed after it would be skipped", cfg.maxDate.Format(dateFormat), listedEnd.Format(dateFormat))
+	}
+	if !cfg.minDate.Before(cfg.maxDate) {
+		log.Fatalf("-min-meeting-date %v is not before -max-meeting-date %v", cfg.minDate.Format(dateFormat), cfg.maxDate.Format(dateFormat))
+	}
+	var err error
+	if cfg.tz, err = time.LoadLocation(tz); err != nil {
+		log.Fatalf("bad -tz %q: %v", tz, err)
+	}
+	if cfg.concurrency < 1 {
+		log.Fatalf("-concurrency %v is less than 1", cfg.concurrency)
+	}
+	if cfg.minChange < 0 || cfg.minChange > 1 {
+		log.Fatalf("-min-agenda-change %v is not between 0 and 1", cfg.minChange)
+	}
+	if cfg.format != "json" && cfg.format != "csv" && cfg.format != "text" {
+		log.Fatalf("unknown -format %q", cfg.format)
+	}
 
 	type action struct {
 		name string
-		fn   func(_ context.Context, _ *sql.DB, _ *rate.Limiter, args []string) error
+		fn   func(_ context.Context, _ *sql.DB, _ *rate.Limiter, _ config, args []string) error
+		// explicit actions only run when named in -only.
+		explicit bool
+		desc     string
 	}
 	actions := []action{
-		{"meetings", processMeetings},
-		{"urls", processExternalContentURLs},
+		{"meetings", processMeetings, false, "list meetings from halifax.ca and eScribe and fetch their agendas"},
+		{"urls", processExternalContentURLs, false, "fetch and extract queued external content urls"},
+		{"build-site", buildSite, true, "write a static site of meetings to -output-dir"},
+		{"check-links", checkLinks, true, "check fetched external content urls for dead links"},
+		{"stats", printStats, true, "print database counts, agenda coverage and last action runs"},
+		{"export", exportMeetings, true, "write meetings with their agendas as JSON"},
+		{"search-bundle", exportSearchBundle, true, "write a compact search index of agenda text for client-side search"},
+		{"reprocess-content", reprocessContent, true, "fetch and extract already fetched external content again"},
+		{"import", importMeetings, true, "read meetings written by export"},
+		{"report", report, true, "write monthly meeting and version counts, agenda lengths, or external content extraction methods"},
+		{"delete-meeting", deleteMeeting, true, "delete a meeting and content only it references"},
+		{"refetch-agendas", refetchAgendas, true, "fetch stored meetings' agendas again by the URLs in -urls-file"},
+		{"list", listMeetings, true, "write meetings between -since and -until"},
+		{"types", listTypes, true, "write meeting types with counts and date ranges"},
+		{"search", search, true, "full-text search agendas and external content"},
+		{"related", relatedMeetings, true, "write meetings with agendas similar to a meeting's"},
+		{"dump-content", dumpContent, true, "write external content text files to -output-dir"},
+		{"recompute", recompute, true, "backfill the derived fields named by -recompute"},
+		{"query", queryDB, true, "run a read-only SQL select and write its rows"},
+		{"dedupe-content", dedupeContent, true, "collapse external content with the same normalized text"},
+		{"reindex", reindex, true, "rebuild the search indexes from stored text"},
+		{"check-associations", checkAssociations, true, "find attachment links to agendas that are no longer current"},
+		{"remap-ids", remapIDs, true, "recompute halifax.ca meeting ids and move or merge meetings whose id changed"},
+		{"changelog", writeChangelog, true, "write new meetings, meeting and agenda updates and new attachments between -since and -until"},
+	}
+	if listActions {
+		for _, a := range actions {
+			only := ""
+			if a.explicit {
+				only = " (only with -only)"
+			}
+			fmt.Printf("%-20v %v%v\n", a.name, a.desc, only)
+		}
+		return
+	}
+
+	const dbPath = "meetings.db"
+	dsn := dbPath + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	if fast {
+		// WAL lets readers proceed during writes and synchronous=NORMAL
+		// skips an fsync per commit. The database stays consistent, but
+		// commits made just before a power loss or OS crash may be lost.
+		dsn += "&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=cache_size(-65536)"
+	} else {
+		// WAL mode is stored in the database file, so switch back to the
+		// default rollback journal in case an earlier run used -fast.
+		dsn += "&_pragma=journal_mode(DELETE)"
 	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(checkStale.vals) > 0 {
+		stale, err := staleActions(db, checkStale.vals, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(stale) > 0 {
+			log.Fatalf("stale: %v", strings.Join(stale, "; "))
+		}
+		log.Println("fresh:", checkStale.String())
+		return
+	}
+
+	var (
+		run               []action
+		runNames, skipped []string
+		known             = make(map[string]bool)
+	)
 	for _, a := range actions {
-		if len(only.vals) > 0 {
+		known[a.name] = true
+		if len(only.vals) > 0 || a.explicit {
 			if _, ok := only.vals[a.name]; !ok {
+				skipped = append(skipped, a.name)
 				continue
 			}
 		}
+		run = append(run, a)
+		runNames = append(runNames, a.name)
+	}
+	if len(only.vals) > 0 {
+		var unknown []string
+		for name := range only.vals {
+			if !known[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		log.Printf("-only=%v running=%v skipped=%v unknown=%v", only.String(), strings.Join(runNames, ","), strings.Join(skipped, ","), strings.Join(unknown, ","))
+	}
+	// Only changelog writes text, the others would silently write json.
+	if cfg.format == "text" {
+		for _, name := range runNames {
+			if name != "changelog" {
+				log.Fatalf("-format text is only for changelog, not %v", name)
+			}
+		}
+	}
+	// The other actions compare dates only.
+	if isTimestamp(cfg.since) || isTimestamp(cfg.until) {
+		for _, name := range runNames {
+			if name != "changelog" {
+				log.Fatalf("-since and -until timestamps are only for changelog, not %v", name)
+			}
+		}
+	}
+
+	if printConfig {
+		absDB, err := filepath.Abs(dbPath)
+		if err != nil {
+			absDB = dbPath
+		}
+		ua := userAgent
+		if ua == "" {
+			ua = defaultUserAgent
+		}
+		_, qpdfErr := exec.LookPath("qpdf")
+		log.Printf("config db=%v fast=%v rate=%v/s burst=%v actions=%v url-batch=%v timeout=%v fetch-timeout=%v action-delay=%v max-meetings=%v escribe-window=%v..%v prefetch-next-window=%v content-cache=%q ocr=%v ocr-checkpoints=%v pdf-repair=%v user-agent=%q from=%q max-idle-conns-per-host=%v idle-conn-timeout=%v change-sinks=%q",
+			absDB, fast, float64(limiter.Limit()), limiter.Burst(), strings.Join(runNames, ","), cfg.urlBatch, cfg.timeout, cfg.fetchTimeout, actionDelay, cfg.maxMeetings, cfg.escribeStart.Format(dateFormat), cfg.escribeEnd.Format(dateFormat), cfg.prefetchNext, cfg.contentCache, !cfg.noOCR, cfg.contentCache != "", qpdfErr == nil, ua, from, maxIdlePerHost, idleConnTimeout, cfg.notifier.String())
+	}
 
-		if err := a.fn(ctx, db, limiter, fs.Args()); err != nil {
+	cfg.dbWriter = newDBWriter(db)
+	defer cfg.dbWriter.Close()
+
+	var summaries []string
+	for i, a := range run {
+		if i > 0 && actionDelay > 0 {
+			log.Println("waiting", actionDelay, "before", a.name)
+			select {
+			case <-ctx.Done():
+				log.Fatal(ctx.Err())
+			case <-time.After(actionDelay):
+			}
+		}
+		cfg.limiterWait = &limiterWait{}
+		cfg.summary = &runSummary{}
+		started := time.Now()
+		if err := a.fn(ctx, db, limiter, cfg, fs.Args()); err != nil {
+			log.Fatal(err)
+		}
+		finished := time.Now()
+		if err := recordActionRun(db, a.name, started, finished, cfg.limiterWait.Total(), cfg.summary); err != nil {
 			log.Fatal(err)
 		}
+		summaries = append(summaries, fmt.Sprintf("action=%v took=%v %v", a.name, finished.Sub(started).Round(time.Millisecond), cfg.summary))
 	}
+
+	for _, s := range summaries {
+		log.Println("summary", strings.TrimSpace(s))
+	}
+	log.Println("summary http connections", conns)
+}
+
+// config holds settings from flags that actions need.
+type config struct {
+	notifier      *notifier
+	outputDir     string
+	urlBatch      int
+	concurrency   int
+	refetchAfter  time.Duration
+	timeout       time.Duration
+	jsonl         bool
+	bundleTextLen int
+	typeCadences  map[string]time.Duration // keyed by type normalized with normalizeType
+	pretty        bool
+	storeHTML     bool
+	contentType   string
+	limit         int
+	contentCache  string
+	httpClient    *http.Client
+	limiterWait   *limiterWait
+	summary       *runSummary // counts for the action being run
+	maxMeetings   int
+	dbWriter      *dbWriter // all writes from fetching actions go through here
+	extracting    *keyLocks // content IDs being extracted by the urls action
+	format        string
+	since, until  string // YYYY-MM-DD, or RFC 3339 for changelog
+	contentHosts  []string
+	events        bool
+	noOCR         bool
+	urlsFile      string
+	escribeStart  time.Time
+	escribeEnd    time.Time
+	minDate       time.Time // -min-meeting-date
+	maxDate       time.Time // -max-meeting-date
+	minAgendaText int
+	recompute     commaSeparatedString
+	excludeTypes  map[string]bool // normalized with normalizeType
+	needProgress  bool
+	apply         bool
+	agendaOnly    bool
+	noExtURLs     bool // -no-external-urls
+	fetchTimeout  time.Duration
+	prefetchNext  bool
+	stripLines    *regexp.Regexp
+	tz            *time.Location // for rendering timestamps
+	minChange     float64        // -min-agenda-change
+	printView     bool
+	parseFailures bool // -store-parse-failures
+	resetCursor   bool
 }
 
 func initDB(db *sql.DB) error {
@@ -64,22 +410,186 @@ func initDB(db *sql.DB) error {
 		`create table if not exists meeting_versions (meeting_id text references meetings (id), observed datetime, schedule_note text, agenda_url text, minutes_url text, video_url text, agenda_content_id references meeting_agenda_content (id), unique (meeting_id, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id))`,
 		`create index if not exists meetings_agenda_content_id on meetings (agenda_content_id)`,
 		`create virtual table if not exists meeting_agenda_content_search using fts5(text, content=meeting_agenda_content)`,
+		`create virtual table if not exists meeting_agenda_content_vocab using fts5vocab(meeting_agenda_content_search, row)`,
 		`create table if not exists external_content (id text primary key, title text, text text)`,
 		`create virtual table if not exists external_content_search using fts5(title, text, content=external_content)`,
 		`create table if not exists external_content_urls (url text primary key, added datetime, fetched datetime, content_type text, size integer, last_modified datetime, etag text, error text, external_content_id text references external_content (id))`,
 		`create table if not exists meeting_external_content_urls (meeting_id text references meetings (id), agenda_content_id references meeting_agenda_content (id), external_content_url text references external_content_urls (url), unique (meeting_id, agenda_content_id, external_content_url))`,
 		`create index if not exists external_content_urls_external_content_id on external_content_urls (external_content_id)`,
 		`create index if not exists meeting_external_content_urls_external_content_url on meeting_external_content_urls (external_content_url)`,
+		`create table if not exists action_runs (action text, started datetime, finished datetime, limiter_wait_ms integer)`,
+		`create table if not exists run_state (action text primary key, last_success datetime)`,
+		`create table if not exists meeting_agendas (meeting_id text references meetings (id), revision integer, url text, agenda_content_id text references meeting_agenda_content (id), observed datetime, unique (meeting_id, revision))`,
+		`create table if not exists listing_cache (url text primary key, page text, fetched datetime)`,
+		`create table if not exists events (id integer primary key, at datetime not null, kind text not null, subject text not null, detail text)`,
+		`create index if not exists events_at on events (at)`,
+		`create table if not exists meeting_agenda_items (agenda_content_id text references meeting_agenda_content (id), position integer, number text, title text, video_offset integer, unique (agenda_content_id, position))`,
+		`create table if not exists parse_failures (url text not null, observed datetime not null, status integer, error text, body_excerpt blob)`,
+		`create index if not exists parse_failures_observed on parse_failures (observed)`,
+		`create table if not exists meeting_public_hearings (meeting_id text references meetings (id), agenda_content_id text references meeting_agenda_content (id), position integer, number text, subject text, unique (meeting_id, agenda_content_id, position))`,
+		`create table if not exists cursors (name text primary key, position integer)`,
+		`create table if not exists migrations (name text primary key, applied datetime)`,
 	}
 	for _, q := range initQueries {
 		if _, err := db.Exec(q); err != nil {
 			return fmt.Errorf("init db: %w", err)
 		}
 	}
+
+	// Columns added after a table was first created.
+	addColumns := []struct{ table, column, def string }{
+		{"external_content_urls", "last_checked", "datetime"},
+		{"external_content_urls", "last_status", "integer"},
+		{"meetings", "session_kind", "text"},
+		{"meetings", "agenda_etag", "text"},
+		{"meetings", "agenda_last_modified", "text"},
+		{"meetings", "agenda_resolved_url", "text"},
+		{"meetings", "last_fetched", "datetime"},
+		{"external_content_urls", "link_text", "text"},
+		{"meetings", "name", "text"},
+		{"meetings", "class_name", "text"},
+		{"external_content_urls", "error_kind", "text"},
+		{"meetings", "starts", "datetime"},
+		{"meetings", "continuation_of", "text references meetings (id)"},
+		{"meeting_external_content_urls", "title", "text"},
+		{"meeting_external_content_urls", "sequence", "integer"},
+		{"external_content", "ocr_skipped", "boolean not null default false"},
+		{"meetings", "agenda_header_hash", "text"},
+		{"meeting_agenda_content", "text_len", "integer"},
+		{"meeting_agenda_content", "html_len", "integer"},
+		{"action_runs", "counts", "text"},
+		{"meeting_agendas", "language", "text"},
+		{"meetings", "ends", "datetime"},
+		{"meetings", "attachment_count", "integer"},
+		{"external_content", "method", "text"},
+		{"meetings", "public_hearing", "boolean not null default false"},
+		{"run_state", "last_failure", "datetime"},
+		{"run_state", "last_error", "text"},
+	}
+	for _, c := range addColumns {
+		if err := addColumn(db, c.table, c.column, c.def); err != nil {
+			return fmt.Errorf("init db: %w", err)
+		}
+	}
+
+	// starts replaced the date-only date column for reads. date is still
+	// written for older readers of the database.
+	if _, err := db.Exec("update meetings set starts=date || ' 00:00:00' where starts is null and date is not null"); err != nil {
+		return fmt.Errorf("init db: backfill meetings.starts: %w", err)
+	}
+	if _, err := db.Exec("create index if not exists meetings_starts on meetings (starts)"); err != nil {
+		return fmt.Errorf("init db: %w", err)
+	}
+
+	// Agenda lengths are in characters, as SQLite's length counts them. HTML
+	// that wasn't stored has no length.
+	if _, err := db.Exec("update meeting_agenda_content set text_len=length(text), html_len=nullif(length(html), 0) where text_len is null"); err != nil {
+		return fmt.Errorf("init db: backfill agenda lengths: %w", err)
+	}
+
+	// Meetings saved before public_hearing was added get it from their
+	// session kind; their agendas are checked when next saved.
+	if _, err := db.Exec("update meetings set public_hearing=true where session_kind=? and not public_hearing", SessionPublicHearing); err != nil {
+		return fmt.Errorf("init db: backfill public hearings: %w", err)
+	}
+
+	// run_state came after action_runs, seed it from runs recorded before.
+	if _, err := db.Exec("insert into run_state (action, last_success) select action, max(finished) from action_runs group by action on conflict (action) do nothing"); err != nil {
+		return fmt.Errorf("init db: seed run_state: %w", err)
+	}
+
+	if err := migrateOnce(db, "rehash-agenda-content", rehashAgendaContent); err != nil {
+		return fmt.Errorf("init db: %w", err)
+	}
+	return nil
+}
+
+// recordActionRun records a successful run of action, both in the
+// action_runs history, along with its summary counts as a JSON object, and
+// as its last success in run_state.
+func recordActionRun(db *sql.DB, action string, started, finished time.Time, limiterWait time.Duration, summary *runSummary) error {
+	var counts sql.NullString
+	if j := summary.JSON(); j != "" {
+		counts = sql.NullString{String: j, Valid: true}
+	}
+	if _, err := db.Exec("insert into action_runs (action, started, finished, limiter_wait_ms, counts) values (?, ?, ?, ?, ?)", action, newTimeValue(&started), newTimeValue(&finished), limiterWait.Milliseconds(), counts); err != nil {
+		return fmt.Errorf("recording %v run: %w", action, err)
+	}
+	if _, err := db.Exec("insert into run_state (action, last_success) values (?, ?) on conflict (action) do update set last_success=excluded.last_success", action, newTimeValue(&finished)); err != nil {
+		return fmt.Errorf("recording %v run state: %w", action, err)
+	}
+	return nil
+}
+
+// staleActions returns a description of each action in maxAge whose last
+// success is older than its duration, or that has never succeeded, with the
+// error it last failed with if it failed since.
+func staleActions(db *sql.DB, maxAge map[string]time.Duration, now time.Time) ([]string, error) {
+	var names []string
+	for name := range maxAge {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var stale []string
+	for _, name := range names {
+		var (
+			last    time.Time
+			lastErr string
+		)
+		if err := db.QueryRow("select last_success, case when last_failure > coalesce(last_success, '') then last_error else '' end from run_state where action=?", name).Scan(newTimeValue(&last), &lastErr); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("select %v run state: %w", name, err)
+		}
+		var desc string
+		switch {
+		case last.IsZero():
+			desc = fmt.Sprintf("%v never succeeded", name)
+		case now.Sub(last) > maxAge[name]:
+			desc = fmt.Sprintf("%v last succeeded %v ago, max %v", name, now.Sub(last).Round(time.Second), maxAge[name])
+		default:
+			continue
+		}
+		if lastErr != "" {
+			desc += fmt.Sprintf(" (last error: %v)", lastErr)
+		}
+		stale = append(stale, desc)
+	}
+	return stale, nil
+}
+
+func addColumn(db *sql.DB, table, column, def string) error {
+	var exists bool
+	if err := db.QueryRow("select count(*) > 0 from pragma_table_info(?) where name=?", table, column).Scan(&exists); err != nil {
+		return fmt.Errorf("checking for %v.%v: %w", table, column, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf("alter table %v add column %v %v", table, column, def)); err != nil {
+		return fmt.Errorf("adding %v.%v: %w", table, column, err)
+	}
 	return nil
 }
 
-const timeFormat = "2006-01-02 15:04:05.999"
+const (
+	timeFormat = "2006-01-02 15:04:05.999"
+	dateFormat = "2006-01-02"
+)
+
+// meetingLocation is the zone meeting times are in. Listings give them as
+// local wall times, which are stored labelled UTC.
+var meetingLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/Halifax")
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}()
+
+// meetingTime returns the instant of t, a stored meeting wall time.
+func meetingTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), meetingLocation)
+}
 
 type timeValue struct {
 	v *time.Time
@@ -111,6 +621,13 @@ func (s timeValue) Scan(src any) error {
 		return nil
 	}
 
+	// Meeting dates were originally stored without a time.
+	t, err = time.ParseInLocation(dateFormat, vs, time.UTC)
+	if err == nil {
+		*s.v = t
+		return nil
+	}
+
 	return fmt.Errorf("could not parse time string %q", vs)
 }
 
@@ -141,3 +658,145 @@ func (c *commaSeparatedString) String() string {
 	sort.Strings(keys)
 	return strings.Join(keys, ",")
 }
+
+// durationMap is a flag value of comma-separated key=duration pairs.
+type durationMap struct {
+	vals map[string]time.Duration
+}
+
+func (d *durationMap) Set(s string) error {
+	d.vals = make(map[string]time.Duration)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("bad key=duration pair %q", kv)
+		}
+		dur, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("bad duration for %q: %w", k, err)
+		}
+		d.vals[strings.TrimSpace(k)] = dur
+	}
+	return nil
+}
+
+func (d *durationMap) String() string {
+	var pairs []string
+	for k, v := range d.vals {
+		pairs = append(pairs, k+"="+v.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// startsRange returns bounds for comparing meetings.starts against -since
+// and -until, as in starts >= from and starts < to. Both are inclusive dates
+// so to is the day after -until.
+func (c config) startsRange() (from, to string) {
+	to = "9999-12-31"
+	if c.until != "" {
+		t, _ := time.Parse(dateFormat, c.until) // validated by dateFlag
+		to = t.AddDate(0, 0, 1).Format(dateFormat)
+	}
+	return c.since, to
+}
+
+// observedRange returns bounds for comparing stored times, such as
+// meeting_versions.observed, against -since and -until, as in observed >=
+// from and observed < to. Dates are whole days in -tz and timestamps are
+// exact, both inclusive.
+func (c config) observedRange() (from, to string) {
+	to = "9999-12-31"
+	if c.since != "" {
+		t, err := time.ParseInLocation(dateFormat, c.since, c.tz)
+		if err != nil {
+			t, _ = time.Parse(time.RFC3339, c.since) // validated by dateFlag
+		}
+		from = t.UTC().Format(timeFormat)
+	}
+	if c.until != "" {
+		t, err := time.ParseInLocation(dateFormat, c.until, c.tz)
+		if err == nil {
+			t = t.AddDate(0, 0, 1)
+		} else {
+			t, _ = time.Parse(time.RFC3339, c.until) // validated by dateFlag
+			// Stored times have millisecond precision.
+			t = t.Truncate(time.Millisecond).Add(time.Millisecond)
+		}
+		to = t.UTC().Format(timeFormat)
+	}
+	return from, to
+}
+
+// escribeListedEnd returns the end of the eScribe calendar listed for
+// -escribe-end, which is a year later with -prefetch-next-window.
+func escribeListedEnd(escribeEnd time.Time, prefetchNext bool) time.Time {
+	if prefetchNext {
+		return escribeEnd.AddDate(1, 0, 0)
+	}
+	return escribeEnd
+}
+
+// defaultMaxMeetingDate returns -max-meeting-date's default: three years
+// from now, or the day after listedEnd if that's later, so meetings from the
+// listed window are never skipped as misparsed.
+func defaultMaxMeetingDate(now, listedEnd time.Time) time.Time {
+	max := now.AddDate(3, 0, 0)
+	if end := listedEnd.AddDate(0, 0, 1); max.Before(end) {
+		max = end
+	}
+	return max
+}
+
+// relDateFlag returns a flag func that stores in dst either a YYYY-MM-DD date
+// or now moved by an offset: a signed number of years (y), months (mo) or
+// days (d), or a time.Duration.
+func relDateFlag(dst *time.Time, now time.Time) func(string) error {
+	return func(s string) error {
+		if t, err := time.ParseInLocation(dateFormat, s, time.Local); err == nil {
+			*dst = t
+			return nil
+		}
+		for _, u := range []struct {
+			suffix string
+			add    func(n int) time.Time
+		}{
+			{"y", func(n int) time.Time { return now.AddDate(n, 0, 0) }},
+			{"mo", func(n int) time.Time { return now.AddDate(0, n, 0) }},
+			{"d", func(n int) time.Time { return now.AddDate(0, 0, n) }},
+		} {
+			if num, ok := strings.CutSuffix(s, u.suffix); ok {
+				n, err := strconv.Atoi(num)
+				if err != nil {
+					break
+				}
+				*dst = u.add(n)
+				return nil
+			}
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("want YYYY-MM-DD or an offset like -2y, -6mo, -90d or -36h")
+		}
+		*dst = now.Add(d)
+		return nil
+	}
+}
+
+// dateFlag returns a flag func that validates a YYYY-MM-DD date or RFC 3339
+// timestamp and stores it in dst.
+func dateFlag(dst *string) func(string) error {
+	return func(s string) error {
+		if _, err := time.Parse(dateFormat, s); err != nil && !isTimestamp(s) {
+			return fmt.Errorf("want YYYY-MM-DD or an RFC 3339 timestamp: %w", err)
+		}
+		*dst = s
+		return nil
+	}
+}
+
+// isTimestamp reports whether s is an RFC 3339 timestamp.
+func isTimestamp(s string) bool {
+	_, err := time.Parse(time.RFC3339, s)
+	return err == nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultMaxMeetingDate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		escribeEnd   time.Time
+		prefetchNext bool
+		want         time.Time
+	}{
+		{"default window", now.AddDate(1, 0, 0), false, now.AddDate(3, 0, 0)},
+		{"default window prefetching next", now.AddDate(1, 0, 0), true, now.AddDate(3, 0, 0)},
+		{"far window", now.AddDate(5, 0, 0), false, now.AddDate(5, 0, 1)},
+		{"window over two years prefetching next", now.AddDate(2, 6, 0), true, now.AddDate(3, 6, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listedEnd := escribeListedEnd(tt.escribeEnd, tt.prefetchNext)
+			got := defaultMaxMeetingDate(now, listedEnd)
+			if !got.Equal(tt.want) {
+				t.Errorf("defaultMaxMeetingDate = %v, want %v", got, tt.want)
+			}
+			// The last listed meeting is kept.
+			if err := checkMeetingDate(listedEnd, time.Time{}, got); err != nil {
+				t.Errorf("meeting at the end of the listed window: %v", err)
+			}
+		})
+	}
+}
+
+func TestObservedRange(t *testing.T) {
+	halifax, err := time.LoadLocation("America/Halifax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		since, until string
+		from, to     string
+	}{
+		{"", "", "", "9999-12-31"},
+		{"2024-03-01", "2024-03-01", "2024-03-01 04:00:00", "2024-03-02 04:00:00"},
+		{"2024-07-01T09:30:00-03:00", "2024-07-01T18:00:00Z", "2024-07-01 12:30:00", "2024-07-01 18:00:00.001"},
+	}
+	for _, tt := range tests {
+		from, to := config{since: tt.since, until: tt.until, tz: halifax}.observedRange()
+		if from != tt.from || to != tt.to {
+			t.Errorf("observedRange(%q, %q) = %q, %q, want %q, %q", tt.since, tt.until, from, to, tt.from, tt.to)
+		}
+	}
+}
@@ -4,15 +4,20 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jxskiss/base62"
 	"golang.org/x/time/rate"
 )
 
-func processMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, args []string) error {
+func processMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
 	cutoff := time.Now().AddDate(0, -1, 0)
 	var maxObserved time.Time
 	if err := db.QueryRow("select max(observed) from meeting_versions").Scan(newTimeValue(&maxObserved)); err != nil {
@@ -22,29 +27,49 @@ func processMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, arg
 		cutoff = maxObserved.AddDate(0, -8, 0)
 	}
 
-	waitLimiter := func() {
-		if err := limiter.Wait(ctx); err != nil {
-			log.Println(err)
-		}
-	}
-
 	type meetingAgendaer struct {
-		m Meeting
-		a agendaer
+		m      Meeting
+		a      agendaer
+		source string
 	}
 	var needMeetings []meetingAgendaer
 
-	var (
-		halifaxCilent = Client{Limiter: waitLimiter}
-		escribeClient = EscribeClient{Limiter: waitLimiter}
-	)
+	halifaxCilent, escribeClient := newClients(ctx, db, limiter, cfg)
 
 	type client interface {
 		List(context.Context, string) ([]Meeting, string, error)
 		agendaer
 	}
 
-	for _, c := range []client{halifaxCilent, escribeClient} {
+	sources := []struct {
+		name string
+		c    client
+	}{{"halifax", halifaxCilent}, {"escribe", escribeClient}}
+
+	// Sources are independent, so one failing only stops its own
+	// meetings. Each one's outcome is recorded in run_state, and the action
+	// only fails if every source did.
+	failed := make(map[string]error)
+	sourcesErr := func() error {
+		var errs []error
+		for _, src := range sources {
+			err := failed[src.name]
+			recordSourceState(cfg, src.name, err)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("source %v: %w", src.name, err))
+			}
+		}
+		cfg.summary.add("failed_sources", len(errs))
+		if len(errs) < len(sources) {
+			return nil
+		}
+		return errors.Join(errs...)
+	}
+
+	excluded := make(map[string]int)
+	for _, src := range sources {
+		c := src.c
+		var listed []meetingAgendaer
 		err := func() error {
 			var token string
 		outer:
@@ -53,12 +78,19 @@ func processMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, arg
 				if err != nil {
 					return fmt.Errorf("listing meetings: %w", err)
 				}
+				recordEvent(cfg, eventListing, src.name, map[string]any{"page": token, "meetings": len(meetings)})
+				cfg.summary.add("listed", len(meetings))
 
 				for _, m := range meetings {
 					if m.Event.Date.Before(cutoff) {
 						break outer
 					}
-					needMeetings = append(needMeetings, meetingAgendaer{m, c})
+					if cfg.excludeTypes[normalizeType(m.Type)] {
+						excluded[m.Type]++
+						cfg.summary.add("excluded", 1)
+						continue
+					}
+					listed = append(listed, meetingAgendaer{m, c, src.name})
 				}
 
 				if nextToken == "" {
@@ -70,17 +102,85 @@ func processMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, arg
 			return nil
 		}()
 		if err != nil {
-			return fmt.Errorf("listing meetings: %w", err)
+			log.Printf("source=%v: %v", src.name, err)
+			failed[src.name] = err
+			continue
+		}
+		needMeetings = append(needMeetings, listed...)
+	}
+	if len(failed) == len(sources) {
+		return sourcesErr()
+	}
+
+	// Listing fails if a source's page structure isn't found, so a source
+	// that listed nothing without failing confirmed its window is empty.
+	// Nothing listed only looks broken when some source couldn't confirm
+	// that.
+	if cfg.needProgress && cfg.summary.get("listed") == 0 && len(failed) > 0 {
+		var unconfirmed []string
+		for _, src := range sources {
+			if failed[src.name] != nil {
+				unconfirmed = append(unconfirmed, src.name)
+			}
+		}
+		sourcesErr() // records each source's state
+		return fmt.Errorf("listing meetings: no meetings listed and sources %v failed", strings.Join(unconfirmed, ","))
+	}
+
+	if len(cfg.excludeTypes) > 0 {
+		if err := logExcludedTypes(db, cfg.excludeTypes, excluded); err != nil {
+			return fmt.Errorf("excluded types: %w", err)
+		}
+	}
+
+	if len(cfg.typeCadences) > 0 {
+		var types, listed []string
+		for t := range cfg.typeCadences {
+			types = append(types, t)
+		}
+		for _, ma := range needMeetings {
+			listed = append(listed, ma.m.Type)
+		}
+		if err := warnUnknownTypes(db, "-type-cadence", types, listed); err != nil {
+			return fmt.Errorf("cadence types: %w", err)
+		}
+
+		lastFetched, err := meetingsLastFetched(db)
+		if err != nil {
+			return fmt.Errorf("select meetings last fetched: %w", err)
+		}
+		now := time.Now()
+		var stale []meetingAgendaer
+		for _, ma := range needMeetings {
+			if isMeetingFresh(ma.m, lastFetched[ma.m.ID], now, cfg.typeCadences) {
+				continue
+			}
+			stale = append(stale, ma)
 		}
+		log.Println("skipping", len(needMeetings)-len(stale), "meetings fetched within their type's cadence")
+		needMeetings = stale
+	}
+
+	if cfg.maxMeetings > 0 && len(needMeetings) > cfg.maxMeetings {
+		log.Println("capping", len(needMeetings), "needed meetings to", cfg.maxMeetings)
+		needMeetings = needMeetings[:cfg.maxMeetings]
 	}
 
 	// TODO: weed out ones we can consider done, such as have non-draft minutes
 	log.Println("need", len(needMeetings), "meetings >=", cutoff.Format(time.RFC3339))
+	cfg.summary.add("needed", len(needMeetings))
 
 	for i, ma := range needMeetings {
-		if err := processMeeting(ctx, db, ma.a, ma.m); err != nil {
-			return fmt.Errorf("processing meeting date=%v type=%v: %w", ma.m.Event.Date.Format("2006-01-02"), ma.m.Type, err)
+		if failed[ma.source] != nil {
+			continue
 		}
+		if err := processMeeting(ctx, db, cfg, ma.a, ma.m); err != nil {
+			err = fmt.Errorf("processing meeting date=%v type=%v: %w", ma.m.Event.Date.Format("2006-01-02"), ma.m.Type, err)
+			log.Printf("source=%v: %v; skipping its remaining meetings", ma.source, err)
+			failed[ma.source] = err
+			continue
+		}
+		cfg.summary.add("processed", 1)
 
 		if (i+1)%10 == 0 {
 			log.Println("completed", i+1, "/", len(needMeetings), "meetings")
@@ -88,78 +188,649 @@ func processMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, arg
 	}
 
 	log.Println("completed", len(needMeetings), "/", len(needMeetings), "meetings")
+	return sourcesErr()
+}
+
+// recordSourceState records a meetings run's outcome for source in
+// run_state, under "meetings/<source>" so -check-stale can watch each source:
+// the time as its last success if err is nil, otherwise as its last failure
+// along with err. Failing to record it is only logged.
+func recordSourceState(cfg config, source string, serr error) {
+	now := time.Now()
+	err := cfg.dbWriter.do(func(db *sql.DB) error {
+		if serr == nil {
+			_, err := db.Exec("insert into run_state (action, last_success) values (?, ?) on conflict (action) do update set last_success=excluded.last_success", "meetings/"+source, newTimeValue(&now))
+			return err
+		}
+		_, err := db.Exec("insert into run_state (action, last_failure, last_error) values (?, ?, ?) on conflict (action) do update set last_failure=excluded.last_failure, last_error=excluded.last_error", "meetings/"+source, newTimeValue(&now), serr.Error())
+		return err
+	})
+	if err != nil {
+		log.Printf("recording run state source=%v: %v", source, err)
+	}
+}
+
+// normalizeType returns a meeting type for comparing, ignoring case and
+// spacing.
+func normalizeType(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// logExcludedTypes logs how many listed meetings of each type were excluded,
+// and warns about excluded types that match no listed or stored meeting, as
+// they're likely typos.
+func logExcludedTypes(db *sql.DB, exclude map[string]bool, excluded map[string]int) error {
+	var types, listed []string
+	for t, n := range excluded {
+		listed = append(listed, t)
+		types = append(types, fmt.Sprintf("%q=%v", t, n))
+	}
+	sort.Strings(types)
+	log.Println("excluded meetings by type:", strings.Join(types, " "))
+
+	var excludeTypes []string
+	for t := range exclude {
+		excludeTypes = append(excludeTypes, t)
+	}
+	return warnUnknownTypes(db, "-exclude-types", excludeTypes, listed)
+}
+
+// warnUnknownTypes warns about types, normalized with normalizeType, that
+// match neither a listed type nor any stored meeting's type.
+func warnUnknownTypes(db *sql.DB, flag string, types, listed []string) error {
+	known := make(map[string]bool)
+	for _, t := range listed {
+		known[normalizeType(t)] = true
+	}
+	stored, err := queryStrings(db, "select distinct type from meetings where type is not null")
+	if err != nil {
+		return err
+	}
+	for _, t := range stored {
+		known[normalizeType(t)] = true
+	}
+	var unknown []string
+	for _, t := range types {
+		if !known[t] {
+			unknown = append(unknown, fmt.Sprintf("%q", t))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Println(flag, "matching no known meeting type:", strings.Join(unknown, " "))
+	}
 	return nil
 }
 
+// newClients returns the halifax.ca and eScribe clients, configured from cfg
+// and waiting on limiter before each request.
+func newClients(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config) (Client, EscribeClient) {
+	waitLimiter := func() {
+		if err := cfg.limiterWait.wait(ctx, limiter); err != nil {
+			log.Println(err)
+		}
+	}
+	return Client{Limiter: waitLimiter, HTTPClient: cfg.httpClient, Retry: defaultRetrier, Pretty: cfg.pretty, ContentHosts: cfg.contentHosts, NoOCR: cfg.noOCR, ListingCache: dbListingCache{db, cfg.dbWriter}, MinDate: cfg.minDate, MaxDate: cfg.maxDate},
+		EscribeClient{Limiter: waitLimiter, HTTPClient: cfg.httpClient, Retry: defaultRetrier, Pretty: cfg.pretty, ContentHosts: cfg.contentHosts, Start: cfg.escribeStart, End: cfg.escribeEnd, PrefetchNext: cfg.prefetchNext, PrintView: cfg.printView, NoOCR: cfg.noOCR, MinDate: cfg.minDate, MaxDate: cfg.maxDate}
+}
+
+// isMeetingFresh reports whether m was fetched recently enough, per its type's
+// entry in cadences, that it doesn't need fetching again. cadences is keyed by
+// type normalized with normalizeType. Types without an entry are never fresh. There's no jitter: the result depends only on its
+// arguments, so a run can be reproduced by passing the same now.
+func isMeetingFresh(m Meeting, lastFetched, now time.Time, cadences map[string]time.Duration) bool {
+	cadence, ok := cadences[normalizeType(m.Type)]
+	if !ok || lastFetched.IsZero() {
+		return false
+	}
+	return now.Sub(lastFetched) < cadence
+}
+
+func meetingsLastFetched(db *sql.DB) (map[string]time.Time, error) {
+	rows, err := db.Query("select id, last_fetched from meetings where last_fetched is not null")
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			id string
+			t  time.Time
+		)
+		if err := rows.Scan(&id, newTimeValue(&t)); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		out[id] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return out, nil
+}
+
 type agendaer interface {
-	Agenda(context.Context, string) (MeetingAgenda, error)
+	Agenda(context.Context, string, Validators) (MeetingAgenda, error)
 }
 
-func processMeeting(ctx context.Context, db *sql.DB, a agendaer, m Meeting) error {
+func processMeeting(ctx context.Context, db *sql.DB, cfg config, a agendaer, m Meeting) error {
+	if cfg.agendaOnly {
+		m = agendaOnlyMeeting(m)
+	}
+	if cfg.noExtURLs {
+		m.Documents = nil
+	}
 	agendaURL := m.URL("agenda")
+	if agendaURL == "" && m.SessionKind == SessionContinuation {
+		// Continuations often have no agenda of their own. Keep them if
+		// there's anything else to record, such as minutes or video.
+		if m.URL("minutes") == "" && m.URL("video") == "" && len(m.Documents) == 0 {
+			log.Printf("skipping empty continuation meeting id=%v type=%v date=%v", m.ID, m.Type, m.Event.Date.Format(dateFormat))
+			return nil
+		}
+		now := time.Now()
+		var newVersion bool
+		err := cfg.dbWriter.do(func(db *sql.DB) (err error) {
+			newVersion, err = saveMeeting(db, m, MeetingAgenda{}, now, cfg.minChange)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if newVersion {
+			cfg.summary.add("new_versions", 1)
+			cfg.notifier.notify(ctx, newMeetingChange(m, "", now))
+		}
+		recordEvent(cfg, eventMeeting, m.ID, map[string]any{"agenda": false})
+		return nil
+	}
 	if agendaURL == "" {
 		return fmt.Errorf("no agenda URL")
 	}
 
-	agenda, err := a.Agenda(ctx, agendaURL)
-	if err != nil {
+	var (
+		prevAgendaURL    sql.NullString
+		prevContentID    sql.NullString
+		prevResolvedURL  sql.NullString
+		prev             Validators
+		prevETag         sql.NullString
+		prevLastModified sql.NullString
+		prevHeaderHash   sql.NullString
+	)
+	if err := db.QueryRow("select agenda_url, agenda_resolved_url, agenda_etag, agenda_last_modified, agenda_header_hash, agenda_content_id from meetings where id=?", m.ID).Scan(&prevAgendaURL, &prevResolvedURL, &prevETag, &prevLastModified, &prevHeaderHash, &prevContentID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select agenda validators: %w", err)
+	}
+	fetchURL := agendaURL
+	if prevAgendaURL.String == agendaURL {
+		prev = Validators{ETag: prevETag.String, LastModified: prevLastModified.String, HeaderHash: prevHeaderHash.String}
+		if prevResolvedURL.String != "" {
+			fetchURL = prevResolvedURL.String
+		}
+	}
+
+	agenda, err := a.Agenda(ctx, fetchURL, prev)
+	if errors.Is(err, ErrNotModified) {
+		agenda, err = storedAgenda(db, m.ID)
+		if err != nil {
+			return fmt.Errorf("loading unmodified agenda: %w", err)
+		}
+		cfg.summary.add("not_modified", 1)
+		agenda.Validators = prev
+		agenda.ResolvedURL = fetchURL
+	} else if err != nil {
+		recordAgendaParseFailure(cfg, fetchURL, err)
 		return fmt.Errorf("fetching agenda: %w", err)
+	} else if n, short := shortAgenda(cfg, agenda); short {
+		log.Printf("not storing short agenda id=%v url=%v len=%v min=%v", m.ID, fetchURL, n, cfg.minAgendaText)
+		recordEvent(cfg, eventMeeting, m.ID, map[string]any{"short_agenda": n})
+		cfg.summary.add("short_agendas", 1)
+
+		// Still save the meeting, so new minutes or video aren't missed,
+		// keeping the agenda it had before if there was one.
+		agenda, err = storedAgenda(db, m.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			agenda = MeetingAgenda{}
+		} else if err != nil {
+			return fmt.Errorf("loading previous agenda: %w", err)
+		} else {
+			agenda.Validators = prev
+			agenda.ResolvedURL = fetchURL
+		}
+		now := time.Now()
+		var newVersion bool
+		err = cfg.dbWriter.do(func(db *sql.DB) (err error) {
+			newVersion, err = saveMeeting(db, m, agenda, now, cfg.minChange)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("saving: %w", err)
+		}
+		if newVersion {
+			cfg.summary.add("new_versions", 1)
+			cfg.notifier.notify(ctx, newMeetingChange(m, agenda.ContentID, now))
+		}
+		return nil
 	}
 
-	if err := saveMeeting(db, m, agenda, time.Now()); err != nil {
+	if !cfg.storeHTML {
+		agenda = withoutHTML(agenda)
+	}
+	if cfg.agendaOnly || cfg.noExtURLs {
+		agenda = withoutContentURLs(agenda)
+	}
+	now := time.Now()
+	var newVersion bool
+	err = cfg.dbWriter.do(func(db *sql.DB) (err error) {
+		newVersion, err = saveMeeting(db, m, agenda, now, cfg.minChange)
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("saving: %w", err)
 	}
+	contentID := agenda.ContentID
+	if contentID == "" {
+		contentID = agendaContentID(agenda.ContentHTML)
+	}
+	if newVersion {
+		cfg.summary.add("new_versions", 1)
+		cfg.notifier.notify(ctx, newMeetingChange(m, contentID, now))
+	}
+	recordEvent(cfg, eventMeeting, m.ID, map[string]any{"agenda_content_id": contentID, "changed": prevContentID.String != contentID})
+
+	// Later agendas, such as a revised agenda, are stored as further
+	// revisions, followed by any French agendas. The first agenda stays the
+	// meeting's main one.
+	extra := m.URLsNamed("agenda")[1:]
+	english := len(extra)
+	extra = append(extra, m.URLsNamed("agenda-fr")...)
+	for i, u := range extra {
+		rev := i + 1
+		ra, err := a.Agenda(ctx, u, Validators{})
+		if err != nil {
+			log.Printf("fetching agenda revision=%v url=%v: %v", rev, u, err)
+			continue
+		}
+		if ra.Language == "" && i >= english {
+			ra.Language = "fr"
+		}
+		if n, short := shortAgenda(cfg, ra); short {
+			log.Printf("not storing short agenda id=%v revision=%v url=%v len=%v min=%v", m.ID, rev, u, n, cfg.minAgendaText)
+			continue
+		}
+		if !cfg.storeHTML {
+			ra = withoutHTML(ra)
+		}
+		if cfg.agendaOnly || cfg.noExtURLs {
+			ra = withoutContentURLs(ra)
+		}
+		err = cfg.dbWriter.do(func(db *sql.DB) error {
+			return saveAgendaRevision(db, m.ID, rev, u, ra, time.Now())
+		})
+		if err != nil {
+			return fmt.Errorf("saving agenda revision %v: %w", rev, err)
+		}
+	}
+
+	// Revisions the meeting no longer lists would otherwise stay searchable
+	// and exported as if current.
+	var pruned int
+	err = cfg.dbWriter.do(func(db *sql.DB) (err error) {
+		pruned, err = pruneAgendaRevisions(db, m.ID, len(extra))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("pruning agenda revisions: %w", err)
+	}
+	if pruned > 0 {
+		log.Printf("pruned superseded agenda revisions id=%v count=%v", m.ID, pruned)
+	}
 	return nil
 }
 
-func saveMeeting(db *sql.DB, m Meeting, agenda MeetingAgenda, observed time.Time) error {
+// shortAgenda returns the length of agenda's text and whether it's under
+// -min-agenda-text. A short agenda usually means the page was a placeholder
+// or its layout changed so the content selector matches the wrong thing.
+func shortAgenda(cfg config, agenda MeetingAgenda) (int, bool) {
+	n := len(strings.TrimSpace(agenda.ContentText))
+	return n, n < cfg.minAgendaText
+}
+
+// withoutHTML drops the agenda's HTML so only its text is stored. The content
+// ID is still computed from the HTML first, so it doesn't depend on
+// -store-html.
+func withoutHTML(agenda MeetingAgenda) MeetingAgenda {
+	if agenda.ContentID == "" {
+		agenda.ContentID = agendaContentID(agenda.ContentHTML)
+	}
+	agenda.ContentHTML = ""
+	return agenda
+}
+
+// storedAgenda loads the agenda content, language and items currently
+// associated with a meeting, for when the agenda page hasn't changed since
+// it was last fetched.
+func storedAgenda(db *sql.DB, meetingID string) (MeetingAgenda, error) {
+	var agenda MeetingAgenda
+	var (
+		attachmentCount sql.NullInt64
+		language        sql.NullString
+	)
+	const q = `select c.id, c.text, c.html, m.attachment_count, a.language from meetings m join meeting_agenda_content c on c.id=m.agenda_content_id left join meeting_agendas a on a.meeting_id=m.id and a.revision=0 where m.id=?`
+	if err := db.QueryRow(q, meetingID).Scan(&agenda.ContentID, &agenda.ContentText, &agenda.ContentHTML, &attachmentCount, &language); err != nil {
+		return MeetingAgenda{}, fmt.Errorf("select: %w", err)
+	}
+	agenda.Language = language.String
+	if attachmentCount.Valid {
+		n := int(attachmentCount.Int64)
+		agenda.AttachmentCount = &n
+	}
+
+	rows, err := db.Query("select number, title, video_offset from meeting_agenda_items where agenda_content_id=? order by position", agenda.ContentID)
+	if err != nil {
+		return MeetingAgenda{}, fmt.Errorf("select items: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item AgendaItem
+		if err := rows.Scan(&item.Number, &item.Title, &item.VideoOffset); err != nil {
+			return MeetingAgenda{}, fmt.Errorf("select items: %w", err)
+		}
+		agenda.Items = append(agenda.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return MeetingAgenda{}, fmt.Errorf("select items: %w", err)
+	}
+	return agenda, nil
+}
+
+// agendaContentID hashes agenda HTML after normalizing whitespace, so that
+// formatting-only changes don't produce a new content ID.
+func agendaContentID(html string) string {
 	contentSum := sha256.New224()
-	fmt.Fprintln(contentSum, agenda.ContentHTML)
-	contentID := base62.EncodeToString(contentSum.Sum(nil))
+	fmt.Fprintln(contentSum, normalizeHTMLSpace(html))
+	return base62.EncodeToString(contentSum.Sum(nil))
+}
+
+var (
+	htmlSpaceRE       = regexp.MustCompile(`\s+`)
+	htmlBetweenTagsRE = regexp.MustCompile(`>\s+<`)
+)
 
+// normalizeHTMLSpace collapses runs of whitespace and drops whitespace between
+// tags. It's only for hashing, the result isn't meant to render identically.
+func normalizeHTMLSpace(html string) string {
+	html = htmlSpaceRE.ReplaceAllString(strings.TrimSpace(html), " ")
+	return htmlBetweenTagsRE.ReplaceAllString(html, "><")
+}
+
+// saveMeeting stores m with its agenda, reporting whether that added a new
+// version of the meeting. Only continuations may lack an agenda URL. agenda
+// is the zero MeetingAgenda if there's no agenda content to store, such as
+// when the agenda page had too little text, in which case it's ignored.
+func saveMeeting(db *sql.DB, m Meeting, agenda MeetingAgenda, observed time.Time, minAgendaChange float64) (bool, error) {
 	agendaURL := m.URL("agenda")
-	if agendaURL == "" {
-		return fmt.Errorf("no agenda URL")
+	if agendaURL == "" && m.SessionKind != SessionContinuation {
+		return false, fmt.Errorf("no agenda URL")
 	}
+	hasAgenda := agendaURL != "" && (agenda.ContentID != "" || agenda.ContentHTML != "" || agenda.ContentText != "")
 
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return false, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	const cq = `insert into meeting_agenda_content (id, text, html) values (?, ?, ?) on conflict (id) do nothing`
-	res, err := tx.Exec(cq, contentID, agenda.ContentText, agenda.ContentHTML)
+	var contentID sql.NullString
+	if hasAgenda {
+		id, err := saveAgendaContent(tx, agenda)
+		if err != nil {
+			return false, err
+		}
+		contentID = sql.NullString{String: id, Valid: true}
+	}
+
+	var name, className sql.NullString
+	if m.Name != "" {
+		name = sql.NullString{String: m.Name, Valid: true}
+	}
+	if m.ClassName != "" {
+		className = sql.NullString{String: m.ClassName, Valid: true}
+	}
+	var agendaForHearings MeetingAgenda
+	if contentID.Valid {
+		agendaForHearings = agenda
+	}
+	publicHearing, hearings := publicHearings(m, agendaForHearings)
+
+	var attachmentCount sql.NullInt64
+	if contentID.Valid && agenda.AttachmentCount != nil {
+		attachmentCount = sql.NullInt64{Int64: int64(*agenda.AttachmentCount), Valid: true}
+	}
+
+	const mq = `insert into meetings (id, type, date, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id, session_kind, agenda_etag, agenda_last_modified, agenda_resolved_url, name, class_name, starts, agenda_header_hash, ends, attachment_count, public_hearing) values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14, ?15, ?16, ?17, ?18, ?19) ON CONFLICT (id) DO UPDATE SET type=excluded.type, date=excluded.date, schedule_note=excluded.schedule_note, agenda_url=excluded.agenda_url, minutes_url=excluded.minutes_url, video_url=excluded.video_url, agenda_content_id=excluded.agenda_content_id, session_kind=excluded.session_kind, agenda_etag=excluded.agenda_etag, agenda_last_modified=excluded.agenda_last_modified, agenda_resolved_url=excluded.agenda_resolved_url, name=excluded.name, class_name=excluded.class_name, starts=excluded.starts, agenda_header_hash=excluded.agenda_header_hash, ends=excluded.ends, attachment_count=excluded.attachment_count, public_hearing=excluded.public_hearing`
+	if _, err := tx.Exec(mq, m.ID, m.Type, m.Event.Date.Format(dateFormat), m.Event.Note, agendaURL, m.URL("minutes"), m.URL("video"), contentID, m.SessionKind, agenda.Validators.ETag, agenda.Validators.LastModified, agenda.ResolvedURL, name, className, newTimeValue(&m.Event.Date), agenda.Validators.HeaderHash, newTimeValue(&m.Event.End), attachmentCount, publicHearing); err != nil {
+		return false, fmt.Errorf("insert meetings: %w", err)
+	}
+
+	var minor bool
+	if minAgendaChange > 0 && contentID.Valid {
+		minor, err = minorAgendaChange(tx, m, agendaURL, contentID.String, agenda.ContentText, minAgendaChange)
+		if err != nil {
+			return false, err
+		}
+	}
+	var versions int64
+	if !minor {
+		const vq = `insert into meeting_versions (meeting_id, observed, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id) values (?1, ?2, ?3, ?4, ?5, ?6, ?7) on conflict do nothing`
+		res, err := tx.Exec(vq, m.ID, newTimeValue(&observed), m.Event.Note, agendaURL, m.URL("minutes"), m.URL("video"), contentID)
+		if err != nil {
+			return false, fmt.Errorf("insert meeting_versions: %w", err)
+		}
+		versions, err = res.RowsAffected()
+		if err != nil {
+			return false, fmt.Errorf("insert meeting_versions: %w", err)
+		}
+	}
+
+	const lq = `update meetings set last_observed=(select max(observed) from meeting_versions where meeting_id=id), last_fetched=? where id=?`
+	if _, err := tx.Exec(lq, newTimeValue(&observed), m.ID); err != nil {
+		return false, fmt.Errorf("update meetings last observed: %w", err)
+	}
+
+	if err := linkContinuation(tx, m); err != nil {
+		return false, err
+	}
+
+	if contentID.Valid {
+		if err := saveAgendaRow(tx, m.ID, 0, agendaURL, contentID.String, agenda.Language, observed); err != nil {
+			return false, err
+		}
+		if err := saveMeetingURLs(tx, observed, m.ID, contentID.String, agenda, m.Documents); err != nil {
+			return false, fmt.Errorf("saving meeting links: %w", err)
+		}
+		if err := savePublicHearings(tx, m.ID, contentID.String, hearings); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit: %w", err)
+	}
+	return versions > 0, nil
+}
+
+// minorAgendaChange reports whether the only difference between m and its
+// latest version is its agenda content, and less than minChange of the
+// agenda's words changed, so no new version should be recorded. Comparisons
+// keep going against that latest version, so small edits that add up are
+// still recorded once they pass minChange.
+func minorAgendaChange(tx *sql.Tx, m Meeting, agendaURL, contentID, text string, minChange float64) (bool, error) {
+	var (
+		note, prevAgendaURL, minutesURL, videoURL, prevContentID sql.NullString
+		prevText                                                 sql.NullString
+	)
+	const q = `select v.schedule_note, v.agenda_url, v.minutes_url, v.video_url, v.agenda_content_id, c.text
+		from meeting_versions v left join meeting_agenda_content c on c.id=v.agenda_content_id
+		where v.meeting_id=? order by v.observed desc limit 1`
+	err := tx.QueryRow(q, m.ID).Scan(&note, &prevAgendaURL, &minutesURL, &videoURL, &prevContentID, &prevText)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("select latest meeting version: %w", err)
+	}
+	if note.String != m.Event.Note || prevAgendaURL.String != agendaURL || minutesURL.String != m.URL("minutes") || videoURL.String != m.URL("video") {
+		return false, nil
+	}
+	if !prevContentID.Valid || prevContentID.String == contentID || !prevText.Valid {
+		return false, nil
+	}
+	return agendaChange(prevText.String, text) < minChange, nil
+}
+
+// agendaChange returns the fraction of words that differ between two agenda
+// texts, from 0 for the same words to 1 for none in common. Words are
+// compared ignoring case and punctuation, and their order isn't considered.
+func agendaChange(prev, cur string) float64 {
+	counts := make(map[string]int)
+	var total int
+	for _, w := range strings.Fields(prev) {
+		if w = normalizeContentText(w); w != "" {
+			counts[w]++
+			total++
+		}
+	}
+	var curTotal, common int
+	for _, w := range strings.Fields(cur) {
+		if w = normalizeContentText(w); w != "" {
+			curTotal++
+			if counts[w] > 0 {
+				counts[w]--
+				common++
+			}
+		}
+	}
+	total = max(total, curTotal)
+	if total == 0 {
+		return 0
+	}
+	return 1 - float64(common)/float64(total)
+}
+
+// linkContinuation points a continuation meeting at the meeting it continues:
+// the latest non-continuation meeting of the same type, and class if known,
+// in the two weeks before it. Listings are newest first, so when m isn't a
+// continuation, unlinked continuations saved before it are linked again.
+func linkContinuation(tx *sql.Tx, m Meeting) error {
+	if m.SessionKind != SessionContinuation {
+		const rq = `select id from meetings where session_kind=? and continuation_of is null and type=? and starts > ? and starts <= datetime(?, '+14 days')`
+		ids, err := queryStrings(tx, rq, SessionContinuation, m.Type, newTimeValue(&m.Event.Date), newTimeValue(&m.Event.Date))
+		if err != nil {
+			return fmt.Errorf("link continuations: %w", err)
+		}
+		for _, id := range ids {
+			if err := linkContinuation(tx, Meeting{ID: id, SessionKind: SessionContinuation}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	const q = `update meetings set continuation_of=(
+		select p.id from meetings p
+		where p.type=meetings.type and p.id != meetings.id
+		and coalesce(p.session_kind, '') != ?2
+		and (meetings.class_name is null or p.class_name is null or p.class_name=meetings.class_name)
+		and p.starts < meetings.starts and p.starts >= datetime(meetings.starts, '-14 days')
+		order by p.starts desc limit 1
+	) where id=?1`
+	if _, err := tx.Exec(q, m.ID, SessionContinuation); err != nil {
+		return fmt.Errorf("link continuation: %w", err)
+	}
+	return nil
+}
+
+// agendaOnlyMeeting drops m's minutes and video URLs and its documents, for
+// -agenda-only.
+func agendaOnlyMeeting(m Meeting) Meeting {
+	var urls []MeetingURL
+	for _, u := range m.URLs {
+		if u.Name != "minutes" && u.Name != "video" {
+			urls = append(urls, u)
+		}
+	}
+	m.URLs = urls
+	m.Documents = nil
+	return m
+}
+
+// withoutContentURLs drops the attachments linked from agenda so they aren't
+// queued for fetching, for -agenda-only and -no-external-urls. The agenda's
+// AttachmentCount is kept.
+func withoutContentURLs(agenda MeetingAgenda) MeetingAgenda {
+	agenda.ContentURLs = nil
+	agenda.ContentURLText = nil
+	return agenda
+}
+
+// meetingAgendaRevisions selects the content IDs of meeting ?1's current
+// agendas with their revision, the main agenda's being 0.
+const meetingAgendaRevisions = `(
+		select agenda_content_id, 0 as revision from meetings where id=?1 and agenda_content_id is not null
+		union select agenda_content_id, min(revision) from meeting_agendas where meeting_id=?1 and agenda_content_id is not null group by agenda_content_id)`
+
+// pruneAgendaRevisions deletes the meeting's stored agenda revisions above
+// keep, such as a revised agenda the meeting no longer lists, along with the
+// links from their content if no current agenda of the meeting has it. It
+// returns how many revisions were deleted.
+func pruneAgendaRevisions(db *sql.DB, meetingID string, keep int) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("insert meeting agenda content: %w", err)
+		return 0, fmt.Errorf("begin tx: %w", err)
 	}
-	ra, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	contentIDs, err := queryStrings(tx, "select agenda_content_id from meeting_agendas where meeting_id=? and revision > ? and agenda_content_id is not null", meetingID, keep)
 	if err != nil {
-		return fmt.Errorf("meeting agenda content rows affected: %w", err)
+		return 0, fmt.Errorf("select superseded agenda revisions: %w", err)
 	}
-	if ra > 0 {
-		const sq = `insert into meeting_agenda_content_search (rowid, text) values ((select rowid from meeting_agenda_content where id=?), ?)`
-		if _, err := tx.Exec(sq, contentID, agenda.ContentText); err != nil {
-			return fmt.Errorf("insert meeting agenda content search: %w", err)
+	res, err := tx.Exec("delete from meeting_agendas where meeting_id=? and revision > ?", meetingID, keep)
+	if err != nil {
+		return 0, fmt.Errorf("delete superseded agenda revisions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete superseded agenda revisions: %w", err)
+	}
+	for _, cid := range contentIDs {
+		if _, err := tx.Exec("delete from meeting_external_content_urls where meeting_id=? and agenda_content_id=? and "+staleAssociationsWhere, meetingID, cid); err != nil {
+			return 0, fmt.Errorf("delete superseded agenda links: %w", err)
 		}
 	}
 
-	const mq = `insert into meetings (id, type, date, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id) values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8) ON CONFLICT (id) DO UPDATE SET type=excluded.type, date=excluded.date, schedule_note=excluded.schedule_note, agenda_url=excluded.agenda_url, minutes_url=excluded.minutes_url, video_url=excluded.video_url, agenda_content_id=excluded.agenda_content_id`
-	if _, err := tx.Exec(mq, m.ID, m.Type, m.Event.Date.Format("2006-01-02"), m.Event.Note, agendaURL, m.URL("minutes"), m.URL("video"), contentID); err != nil {
-		return fmt.Errorf("insert meetings: %w", err)
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
 	}
+	return int(n), nil
+}
 
-	const vq = `insert into meeting_versions (meeting_id, observed, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id) values (?1, ?2, ?3, ?4, ?5, ?6, ?7) on conflict do nothing`
-	if _, err := tx.Exec(vq, m.ID, newTimeValue(&observed), m.Event.Note, agendaURL, m.URL("minutes"), m.URL("video"), contentID); err != nil {
-		return fmt.Errorf("insert meeting_versions: %w", err)
+// saveAgendaRevision stores an additional agenda for a meeting, such as a
+// revised agenda, as the given revision. Its content is indexed and its
+// content URLs queued like the main agenda's.
+func saveAgendaRevision(db *sql.DB, meetingID string, revision int, agendaURL string, agenda MeetingAgenda, observed time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
 	}
+	defer tx.Rollback()
 
-	const lq = `update meetings set last_observed=(select max(observed) from meeting_versions where meeting_id=id) where id=?`
-	if _, err := tx.Exec(lq, m.ID); err != nil {
-		return fmt.Errorf("update meetings last observed: %w", err)
+	contentID, err := saveAgendaContent(tx, agenda)
+	if err != nil {
+		return err
 	}
-
-	if err := saveMeetingURLs(tx, observed, m.ID, contentID, agenda); err != nil {
+	if err := saveAgendaRow(tx, meetingID, revision, agendaURL, contentID, agenda.Language, observed); err != nil {
+		return err
+	}
+	if err := saveMeetingURLs(tx, observed, meetingID, contentID, agenda, nil); err != nil {
 		return fmt.Errorf("saving meeting links: %w", err)
 	}
 
@@ -169,9 +840,71 @@ func saveMeeting(db *sql.DB, m Meeting, agenda MeetingAgenda, observed time.Time
 	return nil
 }
 
-func saveMeetingURLs(tx *sql.Tx, observed time.Time, meetingID, agendaContentID string, agenda MeetingAgenda) error {
+func saveAgendaRow(tx *sql.Tx, meetingID string, revision int, agendaURL, contentID, language string, observed time.Time) error {
+	var lang sql.NullString
+	if language != "" {
+		lang = sql.NullString{String: language, Valid: true}
+	}
+	const q = `insert into meeting_agendas (meeting_id, revision, url, agenda_content_id, observed, language) values (?, ?, ?, ?, ?, ?) on conflict (meeting_id, revision) do update set url=excluded.url, agenda_content_id=excluded.agenda_content_id, observed=excluded.observed, language=excluded.language`
+	if _, err := tx.Exec(q, meetingID, revision, agendaURL, contentID, newTimeValue(&observed), lang); err != nil {
+		return fmt.Errorf("insert meeting agenda revision %v: %w", revision, err)
+	}
+	return nil
+}
+
+// saveAgendaContent stores agenda content, its search entry and its items if
+// the content is new, returning its content ID.
+func saveAgendaContent(tx *sql.Tx, agenda MeetingAgenda) (string, error) {
+	contentID := agenda.ContentID
+	if contentID == "" {
+		contentID = agendaContentID(agenda.ContentHTML)
+	}
+
+	var htmlLen sql.NullInt64
+	if agenda.ContentHTML != "" {
+		htmlLen = sql.NullInt64{Int64: int64(utf8.RuneCountInString(agenda.ContentHTML)), Valid: true}
+	}
+	const cq = `insert into meeting_agenda_content (id, text, html, text_len, html_len) values (?, ?, ?, ?, ?) on conflict (id) do nothing`
+	res, err := tx.Exec(cq, contentID, agenda.ContentText, agenda.ContentHTML, utf8.RuneCountInString(agenda.ContentText), htmlLen)
+	if err != nil {
+		return "", fmt.Errorf("insert meeting agenda content: %w", err)
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("meeting agenda content rows affected: %w", err)
+	}
+	if ra > 0 {
+		const sq = `insert into meeting_agenda_content_search (rowid, text) values ((select rowid from meeting_agenda_content where id=?), ?)`
+		if _, err := tx.Exec(sq, contentID, agenda.ContentText); err != nil {
+			return "", fmt.Errorf("insert meeting agenda content search: %w", err)
+		}
+
+		if err := saveAgendaItems(tx, contentID, agenda.Items); err != nil {
+			return "", err
+		}
+	}
+	return contentID, nil
+}
+
+func saveAgendaItems(tx *sql.Tx, contentID string, items []AgendaItem) error {
+	for i, item := range items {
+		const iq = `insert into meeting_agenda_items (agenda_content_id, position, number, title, video_offset) values (?, ?, ?, ?, ?) on conflict do nothing`
+		if _, err := tx.Exec(iq, contentID, i, item.Number, item.Title, item.VideoOffset); err != nil {
+			return fmt.Errorf("insert meeting agenda item: %w", err)
+		}
+	}
+	return nil
+}
+
+// saveMeetingURLs links the meeting to the content URLs found in its agenda
+// and to the documents listed with it, queueing any new URLs for fetching.
+func saveMeetingURLs(tx *sql.Tx, observed time.Time, meetingID, agendaContentID string, agenda MeetingAgenda, docs []MeetingDocument) error {
 	for _, u := range agenda.ContentURLs {
-		if _, err := tx.Exec("insert into external_content_urls (url, added) values (?, ?) on conflict do nothing", u, newTimeValue(&observed)); err != nil {
+		var linkText sql.NullString
+		if t, ok := agenda.ContentURLText[u]; ok {
+			linkText = sql.NullString{String: t, Valid: true}
+		}
+		if _, err := tx.Exec("insert into external_content_urls (url, added, link_text) values (?, ?, ?) on conflict (url) do update set link_text=coalesce(link_text, excluded.link_text)", u, newTimeValue(&observed), linkText); err != nil {
 			return fmt.Errorf("insert external content URL %v: %w", u, err)
 		}
 
@@ -180,5 +913,20 @@ func saveMeetingURLs(tx *sql.Tx, observed time.Time, meetingID, agendaContentID
 		}
 	}
 
+	for _, d := range docs {
+		var title sql.NullString
+		if d.Title != "" {
+			title = sql.NullString{String: d.Title, Valid: true}
+		}
+		if _, err := tx.Exec("insert into external_content_urls (url, added, link_text) values (?, ?, ?) on conflict (url) do update set link_text=coalesce(link_text, excluded.link_text)", d.URL, newTimeValue(&observed), title); err != nil {
+			return fmt.Errorf("insert external content URL %v: %w", d.URL, err)
+		}
+
+		const q = `insert into meeting_external_content_urls (meeting_id, agenda_content_id, external_content_url, title, sequence) values (?, ?, ?, ?, ?) on conflict (meeting_id, agenda_content_id, external_content_url) do update set title=excluded.title, sequence=excluded.sequence`
+		if _, err := tx.Exec(q, meetingID, agendaContentID, d.URL, title, d.Sequence); err != nil {
+			return fmt.Errorf("insert meeting document %v: %w", d.URL, err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testDB returns a new initialized database in a temporary directory.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "meetings.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := initDB(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAgendaContentIDWhitespace(t *testing.T) {
+	const base = "<div><p>Call to order</p>\n<p>Approval of the minutes</p></div>"
+	tests := []struct {
+		name string
+		html string
+		same bool
+	}{
+		{"identical", base, true},
+		{"indented", "<div>\n  <p>Call to order</p>\n  <p>Approval of the minutes</p>\n</div>", true},
+		{"surrounding space", "\n\t" + base + "\n\n", true},
+		{"crlf", "<div><p>Call to order</p>\r\n<p>Approval of the minutes</p></div>", true},
+		{"runs of spaces in text", "<div><p>Call  to   order</p><p>Approval of the minutes</p></div>", true},
+		{"changed text", "<div><p>Call to order</p><p>Approval of the agenda</p></div>", false},
+		{"space removed within text", "<div><p>Callto order</p><p>Approval of the minutes</p></div>", false},
+		{"changed markup", "<div><p>Call to order</p><p><b>Approval</b> of the minutes</p></div>", false},
+	}
+	want := agendaContentID(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agendaContentID(tt.html); (got == want) != tt.same {
+				t.Errorf("agendaContentID(%q) = %v, base %v; want same=%v", tt.html, got, want, tt.same)
+			}
+		})
+	}
+}
+
+func TestRehashAgendaContent(t *testing.T) {
+	db := testDB(t)
+
+	const html = "<div>\n  <p>Call to order</p>\n</div>"
+	legacyID := legacyAgendaContentID(html)
+	observed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, q := range []struct {
+		q    string
+		args []any
+	}{
+		{"insert into meeting_agenda_content (id, text, html) values (?, ?, ?)", []any{legacyID, "Call to order", html}},
+		{"insert into meeting_agenda_content_search (rowid, text) values ((select rowid from meeting_agenda_content where id=?), ?)", []any{legacyID, "Call to order"}},
+		{"insert into meetings (id, type, agenda_url, agenda_content_id) values (?, ?, ?, ?)", []any{"m1", "Regional Council", "https://example.com/agenda", legacyID}},
+		{"insert into meeting_versions (meeting_id, observed, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id) values (?, ?, '', ?, '', '', ?)", []any{"m1", newTimeValue(&observed), "https://example.com/agenda", legacyID}},
+	} {
+		if _, err := db.Exec(q.q, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rehashAgendaContent(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	newID := agendaContentID(html)
+	for _, q := range []string{
+		"select count(*) from meeting_agenda_content where id=?",
+		"select count(*) from meetings where agenda_content_id=?",
+		"select count(*) from meeting_versions where agenda_content_id=?",
+	} {
+		var n int
+		if err := db.QueryRow(q, newID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%v with new ID = %v, want 1", q, n)
+		}
+	}
+
+	// Saving the same agenda again is no new version.
+	m := Meeting{ID: "m1", Type: "Regional Council", URLs: []MeetingURL{{"agenda", "https://example.com/agenda"}}}
+	newVersion, err := saveMeeting(db, m, MeetingAgenda{ContentHTML: html, ContentText: "Call to order"}, observed.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion {
+		t.Error("saving the unchanged agenda after rehashing recorded a new version")
+	}
+}
+
+func TestPruneAgendaRevisions(t *testing.T) {
+	db := testDB(t)
+	observed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := Meeting{ID: "m1", Type: "Regional Council", URLs: []MeetingURL{{"agenda", "https://example.com/agenda"}}}
+	agenda := MeetingAgenda{ContentHTML: "<p>Agenda</p>", ContentText: "Agenda", ContentURLs: []string{"https://example.com/a.pdf"}}
+	if _, err := saveMeeting(db, m, agenda, observed, 0); err != nil {
+		t.Fatal(err)
+	}
+	for rev, u := range []string{"https://example.com/a.pdf", "https://example.com/b.pdf"} {
+		revised := MeetingAgenda{ContentHTML: fmt.Sprintf("<p>Revised agenda %d</p>", rev+1), ContentText: "Revised agenda", ContentURLs: []string{u}}
+		if err := saveAgendaRevision(db, "m1", rev+1, fmt.Sprintf("https://example.com/agenda/%d", rev+1), revised, observed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := pruneAgendaRevisions(db, "m1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("pruned %v revisions, want 1", n)
+	}
+	revisions, err := queryStrings(db, "select revision from meeting_agendas where meeting_id='m1' order by revision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(revisions, []string{"0", "1"}) {
+		t.Errorf("revisions after pruning = %v, want [0 1]", revisions)
+	}
+	urls, err := meetingContentURLs(context.Background(), db, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].URL != "https://example.com/a.pdf" {
+		t.Errorf("content URLs after pruning = %+v, want only a.pdf", urls)
+	}
+	var links int
+	if err := db.QueryRow("select count(*) from meeting_external_content_urls where external_content_url='https://example.com/b.pdf'").Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 0 {
+		t.Errorf("pruned revision's link to b.pdf was kept")
+	}
+}
+
+// agendaFunc adapts a function to the agendaer interface.
+type agendaFunc func(ctx context.Context, u string, prev Validators) (MeetingAgenda, error)
+
+func (f agendaFunc) Agenda(ctx context.Context, u string, prev Validators) (MeetingAgenda, error) {
+	return f(ctx, u, prev)
+}
+
+func TestProcessMeetingShortAgenda(t *testing.T) {
+	const full = "Call to order, approval of the minutes, and a long list of reports from staff"
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	m := Meeting{
+		ID:    "m1",
+		Type:  "Regional Council",
+		Event: MeetingEvent{Date: starts},
+		URLs:  []MeetingURL{{"agenda", "https://example.com/m1/agenda"}},
+	}
+	short := agendaFunc(func(context.Context, string, Validators) (MeetingAgenda, error) {
+		return MeetingAgenda{ContentHTML: "<p>TBD</p>", ContentText: "TBD"}, nil
+	})
+
+	for _, stored := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stored=%v", stored), func(t *testing.T) {
+			db := testDB(t)
+			cfg := config{dbWriter: newDBWriter(db), minAgendaText: 20}
+			var wantContentID sql.NullString
+			if stored {
+				agenda := MeetingAgenda{ContentHTML: "<p>" + full + "</p>", ContentText: full}
+				if _, err := saveMeeting(db, m, agenda, starts, 0); err != nil {
+					t.Fatal(err)
+				}
+				wantContentID = sql.NullString{String: agendaContentID(agenda.ContentHTML), Valid: true}
+			}
+
+			withMinutes := m
+			withMinutes.URLs = append(slices.Clone(m.URLs), MeetingURL{"minutes", "https://example.com/m1/minutes"})
+			if err := processMeeting(context.Background(), db, cfg, short, withMinutes); err != nil {
+				t.Fatal(err)
+			}
+
+			var minutesURL string
+			var contentID sql.NullString
+			if err := db.QueryRow("select minutes_url, agenda_content_id from meetings where id=?", m.ID).Scan(&minutesURL, &contentID); err != nil {
+				t.Fatal(err)
+			}
+			if minutesURL != "https://example.com/m1/minutes" {
+				t.Errorf("minutes_url = %q, want the new minutes", minutesURL)
+			}
+			if contentID != wantContentID {
+				t.Errorf("agenda_content_id = %v, want %v", contentID, wantContentID)
+			}
+		})
+	}
+}
+
+func TestProcessMeetingNotModifiedKeepsLanguage(t *testing.T) {
+	db := testDB(t)
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	saveTestMeeting(t, db, "m1", starts, "", "")
+	if _, err := db.Exec("update meeting_agendas set language='fr' where meeting_id='m1' and revision=0"); err != nil {
+		t.Fatal(err)
+	}
+	m := Meeting{
+		ID:    "m1",
+		Type:  "Regional Council",
+		Event: MeetingEvent{Date: starts},
+		URLs:  []MeetingURL{{"agenda", "https://example.com/m1/en"}},
+	}
+	notModified := agendaFunc(func(context.Context, string, Validators) (MeetingAgenda, error) {
+		return MeetingAgenda{}, ErrNotModified
+	})
+	cfg := config{dbWriter: newDBWriter(db)}
+	if err := processMeeting(context.Background(), db, cfg, notModified, m); err != nil {
+		t.Fatal(err)
+	}
+
+	var language sql.NullString
+	if err := db.QueryRow("select language from meeting_agendas where meeting_id='m1' and revision=0").Scan(&language); err != nil {
+		t.Fatal(err)
+	}
+	if language.String != "fr" {
+		t.Errorf("language = %v, want fr kept", language)
+	}
+}
+
+func TestIsMeetingFresh(t *testing.T) {
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	cadences := map[string]time.Duration{normalizeType(" Regional  Council"): 7 * 24 * time.Hour}
+	tests := []struct {
+		typ         string
+		lastFetched time.Time
+		want        bool
+	}{
+		{"Regional Council", now.AddDate(0, 0, -1), true},
+		{"regional council ", now.AddDate(0, 0, -1), true},
+		{"Regional Council", now.AddDate(0, 0, -8), false},
+		{"Regional Council", time.Time{}, false},
+		{"Audit and Finance Standing Committee", now.AddDate(0, 0, -1), false},
+	}
+	for _, tt := range tests {
+		m := Meeting{ID: "m1", Type: tt.typ}
+		if got := isMeetingFresh(m, tt.lastFetched, now, cadences); got != tt.want {
+			t.Errorf("isMeetingFresh(%q, %v) = %v, want %v", tt.typ, tt.lastFetched, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jxskiss/base62"
+)
+
+// migrateOnce runs fn in a transaction unless a migration called name has
+// already run, and records that it has. It's for backfills too slow to
+// repeat on every start.
+func migrateOnce(db *sql.DB, name string, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration %v: begin tx: %w", name, err)
+	}
+	defer tx.Rollback()
+
+	var done bool
+	if err := tx.QueryRow("select count(*) > 0 from migrations where name=?", name).Scan(&done); err != nil {
+		return fmt.Errorf("migration %v: %w", name, err)
+	}
+	if done {
+		return nil
+	}
+	if err := fn(tx); err != nil {
+		return fmt.Errorf("migration %v: %w", name, err)
+	}
+	now := time.Now()
+	if _, err := tx.Exec("insert into migrations (name, applied) values (?, ?)", name, newTimeValue(&now)); err != nil {
+		return fmt.Errorf("migration %v: %w", name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %v: commit: %w", name, err)
+	}
+	return nil
+}
+
+// legacyAgendaContentID is how agenda content IDs were hashed before
+// agendaContentID normalized whitespace.
+func legacyAgendaContentID(html string) string {
+	contentSum := sha256.New224()
+	fmt.Fprintln(contentSum, html)
+	return base62.EncodeToString(contentSum.Sum(nil))
+}
+
+// agendaContentIDTables are the tables referring to agenda content by ID.
+var agendaContentIDTables = []string{"meetings", "meeting_versions", "meeting_agendas", "meeting_external_content_urls", "meeting_agenda_items", "meeting_public_hearings"}
+
+// rehashAgendaContent moves agenda content hashed with
+// legacyAgendaContentID to the ID agendaContentID gives its HTML, along with
+// everything referring to it, so the change in hashing doesn't give every
+// meeting a new version when it's next fetched. Content whose new ID is
+// already stored is merged into it, as are versions that then only differ
+// by it. Content not hashed from its stored HTML, such as PDF agendas', is
+// left alone.
+func rehashAgendaContent(tx *sql.Tx) error {
+	rows, err := tx.Query("select id, html from meeting_agenda_content where html is not null and html != ''")
+	if err != nil {
+		return fmt.Errorf("select agenda content: %w", err)
+	}
+	moves := make(map[string]string)
+	for rows.Next() {
+		var id, html string
+		if err := rows.Scan(&id, &html); err != nil {
+			rows.Close()
+			return fmt.Errorf("select agenda content: %w", err)
+		}
+		if newID := agendaContentID(html); newID != id && legacyAgendaContentID(html) == id {
+			moves[id] = newID
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("select agenda content: %w", err)
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+
+	// References move before the content they refer to.
+	if _, err := tx.Exec("pragma defer_foreign_keys = on"); err != nil {
+		return err
+	}
+	for oldID, newID := range moves {
+		for _, table := range agendaContentIDTables {
+			// Rows that would duplicate ones already at the new ID stay
+			// behind and are dropped.
+			if _, err := tx.Exec(fmt.Sprintf("update or ignore %v set agenda_content_id=? where agenda_content_id=?", table), newID, oldID); err != nil {
+				return fmt.Errorf("%v %v: %w", table, oldID, err)
+			}
+			if _, err := tx.Exec(fmt.Sprintf("delete from %v where agenda_content_id=?", table), oldID); err != nil {
+				return fmt.Errorf("%v %v: %w", table, oldID, err)
+			}
+		}
+
+		var exists bool
+		if err := tx.QueryRow("select count(*) > 0 from meeting_agenda_content where id=?", newID).Scan(&exists); err != nil {
+			return fmt.Errorf("agenda content %v: %w", newID, err)
+		}
+		if !exists {
+			// The search index is keyed by rowid, which doesn't change.
+			if _, err := tx.Exec("update meeting_agenda_content set id=? where id=?", newID, oldID); err != nil {
+				return fmt.Errorf("agenda content %v: %w", oldID, err)
+			}
+			continue
+		}
+		for _, q := range []string{
+			`insert into meeting_agenda_content_search (meeting_agenda_content_search, rowid, text) select 'delete', rowid, text from meeting_agenda_content where id=?`,
+			`delete from meeting_agenda_content where id=?`,
+		} {
+			if _, err := tx.Exec(q, oldID); err != nil {
+				return fmt.Errorf("agenda content %v: %w", oldID, err)
+			}
+		}
+	}
+	log.Printf("rehashed agenda content ids=%v", len(moves))
+	return nil
+}
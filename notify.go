@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change kinds, sent as the kind field of each change.
+const (
+	changeMeetingVersion = "meeting_version"
+	changeContent        = "content"
+)
+
+// meetingChange is sent when a meeting is saved with a new version, meaning
+// its schedule note, URLs or agenda content changed, or it's new.
+type meetingChange struct {
+	Kind            string    `json:"kind"`
+	MeetingID       string    `json:"meeting_id"`
+	Type            string    `json:"type"`
+	Name            string    `json:"name,omitempty"`
+	Date            string    `json:"date"`
+	ScheduleNote    string    `json:"schedule_note"`
+	AgendaURL       string    `json:"agenda_url"`
+	MinutesURL      string    `json:"minutes_url"`
+	VideoURL        string    `json:"video_url"`
+	AgendaContentID string    `json:"agenda_content_id,omitempty"`
+	Observed        time.Time `json:"observed"`
+}
+
+func newMeetingChange(m Meeting, agendaContentID string, observed time.Time) meetingChange {
+	return meetingChange{
+		Kind:            changeMeetingVersion,
+		MeetingID:       m.ID,
+		Type:            m.Type,
+		Name:            m.Name,
+		Date:            m.Event.Date.Format(dateFormat),
+		ScheduleNote:    m.Event.Note,
+		AgendaURL:       m.URL("agenda"),
+		MinutesURL:      m.URL("minutes"),
+		VideoURL:        m.URL("video"),
+		AgendaContentID: agendaContentID,
+		Observed:        observed,
+	}
+}
+
+// A changeSink is somewhere changes are sent, such as a webhook.
+type changeSink struct {
+	name string
+	send func(ctx context.Context, b []byte) error
+}
+
+// notifier sends each change, as JSON, to all of its sinks. A sink failing is
+// logged and doesn't affect the other sinks or the run. A nil notifier has no
+// sinks.
+type notifier struct {
+	sinks []changeSink
+}
+
+func (n *notifier) notify(ctx context.Context, change any) {
+	if n == nil || len(n.sinks) == 0 {
+		return
+	}
+	b, err := json.Marshal(change)
+	if err != nil {
+		log.Printf("marshal change: %v", err)
+		return
+	}
+	for _, s := range n.sinks {
+		if err := s.send(ctx, b); err != nil {
+			log.Printf("sending change to %v: %v", s.name, err)
+		}
+	}
+}
+
+func (n *notifier) String() string {
+	var names []string
+	if n != nil {
+		for _, s := range n.sinks {
+			names = append(names, s.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func webhookSink(client *http.Client, webhookURL string) changeSink {
+	return changeSink{
+		name: "webhook " + webhookURL,
+		send: func(ctx context.Context, b []byte) error {
+			return postWebhook(ctx, client, webhookURL, json.RawMessage(b))
+		},
+	}
+}
+
+func logSink() changeSink {
+	return changeSink{
+		name: "log",
+		send: func(ctx context.Context, b []byte) error {
+			log.Printf("change %s", b)
+			return nil
+		},
+	}
+}
+
+// changelogSink appends each change as a line of JSON to the file fn.
+func changelogSink(fn string) changeSink {
+	var mu sync.Mutex
+	return changeSink{
+		name: "changelog " + fn,
+		send: func(ctx context.Context, b []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(append(b, '\n')); err != nil {
+				f.Close()
+				return fmt.Errorf("write %v: %w", fn, err)
+			}
+			return f.Close()
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// queryDB runs the SQL statement given as arguments and prints its rows in
+// -format, at most -limit of them (1000 by default). Only select and with
+// statements are accepted, and the statement runs on a connection with
+// query_only set, so it cannot change the database even if it tries to.
+func queryDB(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	q := strings.TrimSpace(strings.Join(args, " "))
+	q = strings.TrimSpace(strings.TrimRight(q, "; \t\n"))
+	if q == "" {
+		return errors.New("query: need a statement")
+	}
+	if kw := firstKeyword(q); kw != "select" && kw != "with" {
+		return fmt.Errorf("query: only select statements are allowed, got %q", kw)
+	}
+	limit := cfg.limit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "pragma query_only=on"); err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer func() {
+		// The connection goes back to the pool, so later actions must be
+		// able to write with it again.
+		if _, err := conn.ExecContext(context.Background(), "pragma query_only=off"); err != nil {
+			log.Printf("query: reset query_only err=%v", err)
+		}
+	}()
+
+	rows, err := conn.QueryContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+
+	var out []map[string]any
+	var truncated bool
+	for rows.Next() {
+		if len(out) == limit {
+			truncated = true
+			break
+		}
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		r := make(map[string]any, len(cols))
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			r[c] = vals[i]
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	if truncated {
+		log.Printf("query: stopped at limit=%v, raise -limit for more rows", limit)
+	}
+
+	err = writeResults(cfg.format, out, cols, func(r map[string]any) []string {
+		rec := make([]string, len(cols))
+		for i, c := range cols {
+			switch v := r[c].(type) {
+			case nil:
+			case time.Time:
+				rec[i] = v.Format(time.RFC3339)
+			default:
+				rec[i] = fmt.Sprint(v)
+			}
+		}
+		return rec
+	})
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	return nil
+}
+
+// firstKeyword returns the lowercased first word of the SQL statement q,
+// skipping leading comments.
+func firstKeyword(q string) string {
+	for {
+		q = strings.TrimSpace(q)
+		switch {
+		case strings.HasPrefix(q, "--"):
+			i := strings.IndexByte(q, '\n')
+			if i < 0 {
+				return ""
+			}
+			q = q[i+1:]
+		case strings.HasPrefix(q, "/*"):
+			i := strings.Index(q, "*/")
+			if i < 0 {
+				return ""
+			}
+			q = q[i+2:]
+		default:
+			end := strings.IndexFunc(q, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end < 0 {
+				end = len(q)
+			}
+			return strings.ToLower(q[:end])
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/time/rate"
+)
+
+// recomputeChunk is how many rows recompute handles per transaction.
+const recomputeChunk = 500
+
+// A recomputer derives a field from other stored data. ids selects the next
+// chunk of row ids after the given one, in order, and fn recomputes the field
+// for them, returning how many rows changed.
+type recomputer struct {
+	name string
+	ids  string
+	fn   func(tx *sql.Tx, ids []string) (int, error)
+}
+
+// recomputers are in the order they run, later fields may depend on earlier
+// ones.
+var recomputers = []recomputer{
+	{
+		name: "session_kind",
+		ids:  "select id from meetings where id > ? order by id limit ?",
+		fn:   recomputeSessionKind,
+	},
+	{
+		name: "continuation_of",
+		ids:  "select id from meetings where id > ? order by id limit ?",
+		fn:   recomputeContinuationOf,
+	},
+	{
+		name: "agenda_items",
+		// Only eScribe agendas have items, see isHalifaxMeetingID.
+		ids: `select id from (
+			select agenda_content_id as id from meetings where id not like '%/%' and agenda_content_id is not null
+			union select a.agenda_content_id from meeting_agendas a where a.meeting_id not like '%/%'
+		) where id > ? order by id limit ?`,
+		fn: recomputeAgendaItems,
+	},
+}
+
+func recomputerNames() []string {
+	var names []string
+	for _, r := range recomputers {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+// recompute recomputes the derived fields named in -recompute for every
+// stored row, for rolling a new or fixed derivation out to an existing
+// database. Rows are handled in chunks, each in its own transaction, and
+// recomputing a field again gives the same result, so it can be interrupted
+// and run again.
+func recompute(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if len(cfg.recompute.vals) == 0 {
+		return fmt.Errorf("recompute: need -recompute with some of %v", strings.Join(recomputerNames(), ","))
+	}
+	for name := range cfg.recompute.vals {
+		if !slices.Contains(recomputerNames(), name) {
+			return fmt.Errorf("recompute: unknown field %q", name)
+		}
+	}
+
+	for _, r := range recomputers {
+		if _, ok := cfg.recompute.vals[r.name]; !ok {
+			continue
+		}
+
+		var after string
+		var rows, changed int
+		for {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("recompute %v: %w", r.name, err)
+			}
+			ids, err := queryStrings(db, r.ids, after, recomputeChunk)
+			if err != nil {
+				return fmt.Errorf("recompute %v: %w", r.name, err)
+			}
+			if len(ids) == 0 {
+				break
+			}
+
+			err = cfg.dbWriter.do(func(db *sql.DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return fmt.Errorf("begin tx: %w", err)
+				}
+				defer tx.Rollback()
+
+				n, err := r.fn(tx, ids)
+				if err != nil {
+					return err
+				}
+				if err := tx.Commit(); err != nil {
+					return fmt.Errorf("commit: %w", err)
+				}
+				changed += n
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("recompute %v: %w", r.name, err)
+			}
+			rows += len(ids)
+			after = ids[len(ids)-1]
+		}
+		log.Printf("recomputed %v rows=%v changed=%v", r.name, rows, changed)
+	}
+	return nil
+}
+
+// recomputeSessionKind classifies meetings as their client does when listing
+// them: halifax.ca from the schedule note, eScribe from the type and name.
+func recomputeSessionKind(tx *sql.Tx, ids []string) (int, error) {
+	var changed int
+	for _, id := range ids {
+		var note, typ, name, kind sql.NullString
+		if err := tx.QueryRow("select schedule_note, type, name, session_kind from meetings where id=?", id).Scan(&note, &typ, &name, &kind); err != nil {
+			return 0, fmt.Errorf("select meeting %v: %w", id, err)
+		}
+		want := sessionKind(typ.String + " " + name.String)
+		if isHalifaxMeetingID(id) {
+			want = sessionKind(note.String)
+		}
+		if kind.Valid && kind.String == want {
+			continue
+		}
+		if _, err := tx.Exec("update meetings set session_kind=? where id=?", want, id); err != nil {
+			return 0, fmt.Errorf("update meeting %v: %w", id, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// isHalifaxMeetingID reports whether id is from a halifax.ca listing rather
+// than eScribe. halifax.ca meeting ids are agenda URL paths, eScribe's are
+// GUIDs.
+func isHalifaxMeetingID(id string) bool {
+	return strings.Contains(id, "/")
+}
+
+// recomputeContinuationOf links continuations to the meeting they continue
+// and unlinks meetings that are no longer continuations.
+func recomputeContinuationOf(tx *sql.Tx, ids []string) (int, error) {
+	var changed int
+	for _, id := range ids {
+		var kind, before sql.NullString
+		if err := tx.QueryRow("select session_kind, continuation_of from meetings where id=?", id).Scan(&kind, &before); err != nil {
+			return 0, fmt.Errorf("select meeting %v: %w", id, err)
+		}
+		if kind.String == SessionContinuation {
+			if err := linkContinuation(tx, Meeting{ID: id, SessionKind: SessionContinuation}); err != nil {
+				return 0, err
+			}
+		} else if _, err := tx.Exec("update meetings set continuation_of=null where id=?", id); err != nil {
+			return 0, fmt.Errorf("update meeting %v: %w", id, err)
+		}
+
+		var after sql.NullString
+		if err := tx.QueryRow("select continuation_of from meetings where id=?", id).Scan(&after); err != nil {
+			return 0, fmt.Errorf("select meeting %v: %w", id, err)
+		}
+		if after != before {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// recomputeAgendaItems extracts items from stored eScribe agenda HTML again.
+// Agendas stored without HTML are left alone.
+func recomputeAgendaItems(tx *sql.Tx, ids []string) (int, error) {
+	var changed int
+	for _, id := range ids {
+		var html sql.NullString
+		if err := tx.QueryRow("select html from meeting_agenda_content where id=?", id).Scan(&html); errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("select agenda content %v: %w", id, err)
+		}
+		if html.String == "" {
+			continue
+		}
+
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html.String))
+		if err != nil {
+			return 0, fmt.Errorf("agenda content %v: %w", id, err)
+		}
+		items := escribeAgendaItems(doc.Selection)
+
+		before, err := agendaItems(tx, id)
+		if err != nil {
+			return 0, fmt.Errorf("agenda content %v: %w", id, err)
+		}
+		if slices.EqualFunc(before, items, sameAgendaItem) {
+			continue
+		}
+		if _, err := tx.Exec("delete from meeting_agenda_items where agenda_content_id=?", id); err != nil {
+			return 0, fmt.Errorf("delete agenda items %v: %w", id, err)
+		}
+		if err := saveAgendaItems(tx, id, items); err != nil {
+			return 0, fmt.Errorf("agenda content %v: %w", id, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func agendaItems(tx *sql.Tx, contentID string) ([]AgendaItem, error) {
+	rows, err := tx.Query("select number, title, video_offset from meeting_agenda_items where agenda_content_id=? order by position", contentID)
+	if err != nil {
+		return nil, fmt.Errorf("select agenda items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []AgendaItem
+	for rows.Next() {
+		var (
+			item   AgendaItem
+			offset sql.NullInt64
+		)
+		if err := rows.Scan(&item.Number, &item.Title, &offset); err != nil {
+			return nil, fmt.Errorf("scan agenda item: %w", err)
+		}
+		if offset.Valid {
+			o := int(offset.Int64)
+			item.VideoOffset = &o
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select agenda items: %w", err)
+	}
+	return items, nil
+}
+
+func sameAgendaItem(a, b AgendaItem) bool {
+	if a.Number != b.Number || a.Title != b.Title || (a.VideoOffset == nil) != (b.VideoOffset == nil) {
+		return false
+	}
+	return a.VideoOffset == nil || *a.VideoOffset == *b.VideoOffset
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// refetchAgendas fetches and processes the meetings whose agendas are listed
+// in -urls-file, one URL per line, without listing meetings or considering
+// when they were last fetched. Blank lines and lines starting with # are
+// ignored. A URL must be the main or a revised agenda of a stored meeting.
+// Each URL's result is logged and the action fails if any did.
+func refetchAgendas(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if cfg.urlsFile == "" {
+		return errors.New("refetch-agendas: need -urls-file")
+	}
+	urls, err := readURLsFile(cfg.urlsFile)
+	if err != nil {
+		return fmt.Errorf("refetch-agendas: %w", err)
+	}
+
+	halifaxClient, escribeClient := newClients(ctx, db, limiter, cfg)
+
+	var failed int
+	for _, u := range urls {
+		err := func() error {
+			m, err := storedMeetingForAgenda(db, u)
+			if err != nil {
+				return err
+			}
+			var a agendaer = halifaxClient
+			if isEscribeURL(u) {
+				a = escribeClient
+			}
+			return processMeeting(ctx, db, cfg, a, m)
+		}()
+		if err != nil {
+			failed++
+			log.Printf("refetch failed url=%v: %v", u, err)
+			continue
+		}
+		log.Printf("refetched url=%v", u)
+	}
+
+	log.Println("refetched", len(urls)-failed, "/", len(urls), "agenda urls")
+	if failed > 0 {
+		return fmt.Errorf("refetch-agendas: %v of %v urls failed", failed, len(urls))
+	}
+	return nil
+}
+
+func readURLsFile(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var urls []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		urls = append(urls, l)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read %v: %w", fn, err)
+	}
+	return urls, nil
+}
+
+// storedMeetingForAgenda rebuilds the meeting with agenda URL u from what was
+// stored when it was last listed, including its revised agendas and
+// documents.
+func storedMeetingForAgenda(db *sql.DB, u string) (Meeting, error) {
+	var (
+		m                               Meeting
+		className, sessionKind          sql.NullString
+		agendaURL, minutesURL, videoURL string
+		contentID                       sql.NullString
+	)
+	const q = `select id, type, coalesce(name, ''), class_name, session_kind, starts, ends, schedule_note, agenda_url, minutes_url, video_url, agenda_content_id
+		from meetings where id=(select id from meetings where agenda_url=?1 union select meeting_id from meeting_agendas where url=?1 limit 1)`
+	err := db.QueryRow(q, u).Scan(&m.ID, &m.Type, &m.Name, &className, &sessionKind, newTimeValue(&m.Event.Date), newTimeValue(&m.Event.End), &m.Event.Note, &agendaURL, &minutesURL, &videoURL, &contentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Meeting{}, errors.New("no stored meeting has this agenda")
+	} else if err != nil {
+		return Meeting{}, fmt.Errorf("select meeting: %w", err)
+	}
+	m.ClassName = className.String
+	m.SessionKind = sessionKind.String
+
+	for _, mu := range []MeetingURL{{"agenda", agendaURL}, {"minutes", minutesURL}, {"video", videoURL}} {
+		if mu.URL != "" {
+			m.URLs = append(m.URLs, mu)
+		}
+	}
+	for _, name := range []string{"agenda", "agenda-fr"} {
+		revisions, err := queryStrings(db, "select url from meeting_agendas where meeting_id=? and revision > 0 and (language is 'fr') = ? order by revision", m.ID, name == "agenda-fr")
+		if err != nil {
+			return Meeting{}, fmt.Errorf("select agenda revisions: %w", err)
+		}
+		for _, r := range revisions {
+			m.URLs = append(m.URLs, MeetingURL{name, r})
+		}
+	}
+
+	rows, err := db.Query("select external_content_url, coalesce(title, ''), sequence from meeting_external_content_urls where meeting_id=? and agenda_content_id is ? and sequence is not null order by sequence", m.ID, contentID)
+	if err != nil {
+		return Meeting{}, fmt.Errorf("select documents: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d MeetingDocument
+		if err := rows.Scan(&d.URL, &d.Title, &d.Sequence); err != nil {
+			return Meeting{}, fmt.Errorf("select documents: %w", err)
+		}
+		m.Documents = append(m.Documents, d)
+	}
+	if err := rows.Err(); err != nil {
+		return Meeting{}, fmt.Errorf("select documents: %w", err)
+	}
+	return m, nil
+}
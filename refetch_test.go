@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoredMeetingForAgendaEnds(t *testing.T) {
+	db := testDB(t)
+
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	ends := starts.Add(3 * time.Hour)
+	const q = `insert into meetings (id, type, agenda_url, minutes_url, video_url, schedule_note, starts, ends) values ('m1', 'Regional Council', 'https://example.com/agenda', '', '', '', ?, ?)`
+	if _, err := db.Exec(q, newTimeValue(&starts), newTimeValue(&ends)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := storedMeetingForAgenda(db, "https://example.com/agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Event.Date.Equal(starts) || !m.Event.End.Equal(ends) {
+		t.Errorf("got starts=%v ends=%v, want %v and %v", m.Event.Date, m.Event.End, starts, ends)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+)
+
+// reindex rebuilds the agenda and external content search indexes from the
+// stored text, without fetching anything. If -strip-lines is set, lines
+// matching it are first removed from the stored text, so boilerplate stops
+// matching searches. That permanently rewrites the text, which then no
+// longer matches stored agenda HTML, so it's only done with -apply; without
+// it, reindex only reports how many rows would change.
+func reindex(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("reindex: begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if cfg.stripLines != nil {
+		for _, t := range []struct {
+			table, update string
+		}{
+			{"meeting_agenda_content", "update meeting_agenda_content set text=?, text_len=? where id=?"},
+			{"external_content", "update external_content set text=? where id=?"},
+		} {
+			changed, err := normalizedTexts(tx, t.table, cfg.stripLines)
+			if err != nil {
+				return fmt.Errorf("reindex: %v: %w", t.table, err)
+			}
+			if !cfg.apply {
+				log.Printf("reindex: would strip lines from table=%v rows=%v; run with -apply to rewrite the stored text", t.table, len(changed))
+				continue
+			}
+			for id, text := range changed {
+				uargs := []any{text, id}
+				if t.table == "meeting_agenda_content" {
+					uargs = []any{text, utf8.RuneCountInString(text), id}
+				}
+				if _, err := tx.Exec(t.update, uargs...); err != nil {
+					return fmt.Errorf("reindex: %v %v: %w", t.table, id, err)
+				}
+			}
+			log.Printf("reindex: stripped lines from table=%v rows=%v", t.table, len(changed))
+			cfg.summary.add(t.table+"_stripped", len(changed))
+		}
+	}
+
+	if cfg.stripLines != nil && !cfg.apply {
+		return nil
+	}
+
+	// Both search tables index their content tables, so rebuild reads the
+	// current text back from them.
+	for _, fts := range []string{"meeting_agenda_content_search", "external_content_search"} {
+		if _, err := tx.Exec(fmt.Sprintf("insert into %[1]v (%[1]v) values ('rebuild')", fts)); err != nil {
+			return fmt.Errorf("reindex: rebuild %v: %w", fts, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("reindex: commit: %w", err)
+	}
+	log.Println("reindex: rebuilt search indexes")
+	return nil
+}
+
+// normalizedTexts returns the text of each row in table that stripLines
+// changes, by id.
+func normalizedTexts(tx *sql.Tx, table string, re *regexp.Regexp) (map[string]string, error) {
+	rows, err := tx.Query(fmt.Sprintf("select id, text from %v where coalesce(text, '') != ''", table))
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer rows.Close()
+
+	changed := make(map[string]string)
+	for rows.Next() {
+		var id, text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		if s := stripLines(text, re); s != text {
+			changed[id] = s
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return changed, nil
+}
+
+// stripLines removes the lines of text matching re. Blank lines left next
+// to each other by a removal are collapsed into one.
+func stripLines(text string, re *regexp.Regexp) string {
+	lines := strings.SplitAfter(text, "\n")
+	out := lines[:0]
+	var stripped bool
+	for _, l := range lines {
+		if re.MatchString(strings.TrimRight(l, "\r\n")) {
+			stripped = true
+			continue
+		}
+		if stripped && strings.TrimSpace(l) == "" && len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+			continue
+		}
+		stripped = stripped && strings.TrimSpace(l) == ""
+		out = append(out, l)
+	}
+	return strings.Join(out, "")
+}
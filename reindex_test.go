@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReindexStripLinesApply(t *testing.T) {
+	db := testDB(t)
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	saveTestMeeting(t, db, "m1", starts, "Call to order\nPage 1 of 2\nAdjournment", "")
+
+	text := func() string {
+		t.Helper()
+		var s string
+		if err := db.QueryRow("select c.text from meetings m join meeting_agenda_content c on c.id=m.agenda_content_id where m.id='m1'").Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	matches := func(q string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("select count(*) from meeting_agenda_content_search where meeting_agenda_content_search match ?", q).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	cfg := config{stripLines: regexp.MustCompile(`^Page \d+ of \d+$`)}
+	if err := reindex(context.Background(), db, nil, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "Call to order\nPage 1 of 2\nAdjournment" {
+		t.Errorf("text without -apply = %q, want it unchanged", got)
+	}
+
+	cfg.apply = true
+	if err := reindex(context.Background(), db, nil, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "Call to order\nAdjournment" {
+		t.Errorf("text with -apply = %q, want the page line stripped", got)
+	}
+	if n := matches("page"); n != 0 {
+		t.Errorf("stripped text still matches %v times", n)
+	}
+	if n := matches("adjournment"); n != 1 {
+		t.Errorf("kept text matches %v times, want 1", n)
+	}
+}
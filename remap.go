@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type remapRow struct {
+	OldID string `json:"old_id"`
+	NewID string `json:"new_id"`
+	Kept  bool   `json:"kept"` // this row's data is kept; other rows mapping to new_id are merged into it
+}
+
+// remapIDs recomputes the IDs of halifax.ca meetings with meetingID, for when
+// how IDs are derived from agenda URLs changes, and writes the meetings whose
+// ID would change. With -apply, each is moved to its new ID along with its
+// versions, agendas, content URL associations, public hearings,
+// continuations and events, in one transaction. Meetings that now share an
+// ID are merged: the row already at the new ID is kept if there is one,
+// otherwise the most recently observed one, and the others' versions and
+// associations are added to it where they don't duplicate its own. The
+// listing cache is cleared so the next listing isn't parsed with the old
+// IDs.
+func remapIDs(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("remap-ids: begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	groups, err := remapGroups(tx)
+	if err != nil {
+		return fmt.Errorf("remap-ids: %w", err)
+	}
+
+	var out []remapRow
+	for _, g := range groups {
+		for i, id := range g.ids {
+			if id != g.newID {
+				out = append(out, remapRow{OldID: id, NewID: g.newID, Kept: i == 0})
+			}
+		}
+	}
+	cfg.summary.add("remapped", len(out))
+
+	header := []string{"old_id", "new_id", "kept"}
+	err = writeResults(cfg.format, out, header, func(r remapRow) []string {
+		return []string{r.OldID, r.NewID, fmt.Sprint(r.Kept)}
+	})
+	if err != nil {
+		return fmt.Errorf("remap-ids: %w", err)
+	}
+
+	if !cfg.apply {
+		log.Printf("remap-ids: would remap meetings=%v into ids=%v; run with -apply to do it", len(out), len(groups))
+		return nil
+	}
+	if len(out) == 0 {
+		return nil
+	}
+
+	// Rows move between IDs one table at a time, so references only line
+	// up again once all of them have moved.
+	if _, err := tx.Exec("pragma defer_foreign_keys = on"); err != nil {
+		return fmt.Errorf("remap-ids: %w", err)
+	}
+	for _, g := range groups {
+		if err := moveMeetings(tx, g); err != nil {
+			return fmt.Errorf("remap-ids: %v: %w", g.newID, err)
+		}
+	}
+	if _, err := tx.Exec("delete from listing_cache"); err != nil {
+		return fmt.Errorf("remap-ids: clear listing cache: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("remap-ids: commit: %w", err)
+	}
+	log.Printf("remap-ids: remapped meetings=%v into ids=%v", len(out), len(groups))
+	return nil
+}
+
+// remapGroup is the meetings that map to newID. ids[0] is the one kept.
+type remapGroup struct {
+	newID string
+	ids   []string
+}
+
+// remapGroups returns the groups of meetings with at least one whose ID
+// differs from what meetingID now gives, sorted by new ID. eScribe meetings
+// keep the IDs eScribe gives them, so they're left alone; halifax.ca IDs
+// always have a slash and eScribe ones never do.
+func remapGroups(tx *sql.Tx) ([]remapGroup, error) {
+	rows, err := tx.Query("select id, coalesce(agenda_url, ''), coalesce(type, ''), starts, last_observed from meetings")
+	if err != nil {
+		return nil, fmt.Errorf("select meetings: %w", err)
+	}
+	defer rows.Close()
+
+	type member struct {
+		id       string
+		observed time.Time
+	}
+	byNewID := make(map[string][]member)
+	for rows.Next() {
+		var (
+			id, agendaURL, typ string
+			starts, observed   time.Time
+		)
+		if err := rows.Scan(&id, &agendaURL, &typ, newTimeValue(&starts), newTimeValue(&observed)); err != nil {
+			return nil, fmt.Errorf("select meetings: %w", err)
+		}
+		newID := id
+		if strings.Contains(id, "/") && !isEscribeURL(agendaURL) {
+			newID = meetingID(agendaURL, typ, starts)
+		}
+		byNewID[newID] = append(byNewID[newID], member{id, observed})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select meetings: %w", err)
+	}
+
+	var groups []remapGroup
+	for newID, ms := range byNewID {
+		if len(ms) == 1 && ms[0].id == newID {
+			continue
+		}
+		sort.Slice(ms, func(i, j int) bool {
+			if (ms[i].id == newID) != (ms[j].id == newID) {
+				return ms[i].id == newID
+			}
+			if !ms[i].observed.Equal(ms[j].observed) {
+				return ms[i].observed.After(ms[j].observed)
+			}
+			return ms[i].id < ms[j].id
+		})
+		g := remapGroup{newID: newID}
+		for _, m := range ms {
+			g.ids = append(g.ids, m.id)
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].newID < groups[j].newID })
+	return groups, nil
+}
+
+// moveMeetings moves the meetings in g to g.newID. The kept meeting moves
+// first so its rows win where merged rows would duplicate them.
+func moveMeetings(tx *sql.Tx, g remapGroup) error {
+	for i, id := range g.ids {
+		if id == g.newID {
+			continue
+		}
+		if i == 0 {
+			if _, err := tx.Exec("update meetings set id=? where id=?", g.newID, id); err != nil {
+				return fmt.Errorf("meetings %v: %w", id, err)
+			}
+		}
+		for _, table := range []string{"meeting_versions", "meeting_agendas", "meeting_external_content_urls", "meeting_public_hearings"} {
+			// Rows that would duplicate ones already at the new ID stay
+			// behind and are dropped.
+			if _, err := tx.Exec(fmt.Sprintf("update or ignore %v set meeting_id=? where meeting_id=?", table), g.newID, id); err != nil {
+				return fmt.Errorf("%v %v: %w", table, id, err)
+			}
+			if _, err := tx.Exec(fmt.Sprintf("delete from %v where meeting_id=?", table), id); err != nil {
+				return fmt.Errorf("%v %v: %w", table, id, err)
+			}
+		}
+		if _, err := tx.Exec("update meetings set continuation_of=? where continuation_of=?", g.newID, id); err != nil {
+			return fmt.Errorf("continuations of %v: %w", id, err)
+		}
+		if _, err := tx.Exec("update events set subject=? where kind=? and subject=?", g.newID, eventMeeting, id); err != nil {
+			return fmt.Errorf("events %v: %w", id, err)
+		}
+		if i > 0 {
+			if _, err := tx.Exec("delete from meetings where id=?", id); err != nil {
+				return fmt.Errorf("meetings %v: %w", id, err)
+			}
+		}
+	}
+
+	const lq = `update meetings set last_observed=(select max(observed) from meeting_versions where meeting_id=id) where id=?`
+	if _, err := tx.Exec(lq, g.newID); err != nil {
+		return fmt.Errorf("last observed: %w", err)
+	}
+	return nil
+}
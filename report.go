@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"golang.org/x/time/rate"
+)
+
+type reportRow struct {
+	Kind  string `json:"kind"` // meetings or versions
+	Month string `json:"month"`
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// report writes monthly counts of meetings and new agenda versions by meeting
+// type, optionally limited to -since and -until. With the argument lengths it
+// writes agenda lengths instead, see reportLengths, and with methods it writes
+// how external content text was extracted, see reportMethods.
+func report(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if len(args) > 0 && args[0] == "lengths" {
+		return reportLengths(ctx, db, cfg)
+	}
+	if len(args) > 0 && args[0] == "methods" {
+		return reportMethods(ctx, db, cfg)
+	}
+	since, until := cfg.startsRange()
+
+	queries := []struct {
+		kind string
+		q    string
+	}{
+		{"meetings", `select substr(starts, 1, 7) as month, type, count(*) from meetings where starts >= ? and starts < ? group by month, type order by month, type`},
+		{"versions", `select substr(v.observed, 1, 7) as month, m.type, count(*) from meeting_versions v join meetings m on m.id=v.meeting_id where substr(v.observed, 1, 10) >= ? and substr(v.observed, 1, 10) < ? group by month, m.type order by month, m.type`},
+	}
+
+	var out []reportRow
+	for _, q := range queries {
+		rows, err := db.QueryContext(ctx, q.q, since, until)
+		if err != nil {
+			return fmt.Errorf("report: %v: %w", q.kind, err)
+		}
+		for rows.Next() {
+			r := reportRow{Kind: q.kind}
+			if err := rows.Scan(&r.Month, &r.Type, &r.Count); err != nil {
+				rows.Close()
+				return fmt.Errorf("report: %v: %w", q.kind, err)
+			}
+			out = append(out, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("report: %v: %w", q.kind, err)
+		}
+	}
+
+	header := []string{"kind", "month", "type", "count"}
+	err := writeResults(cfg.format, out, header, func(r reportRow) []string {
+		return []string{r.Kind, r.Month, r.Type, strconv.Itoa(r.Count)}
+	})
+	if err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	return nil
+}
+
+type lengthsRow struct {
+	Month      string `json:"month"`
+	Agendas    int    `json:"agendas"`
+	TextP10    int    `json:"text_p10"`
+	TextMedian int    `json:"text_median"`
+	TextP90    int    `json:"text_p90"`
+	HTMLMedian int    `json:"html_median"` // 0 if no HTML was stored
+}
+
+// reportLengths writes, by month observed, the distribution of the lengths of
+// agenda contents first seen that month. A sudden drop in text length across
+// the board usually means agenda parsing broke.
+func reportLengths(ctx context.Context, db *sql.DB, cfg config) error {
+	since, until := cfg.startsRange()
+
+	const q = `select substr(v.first, 1, 7) as month, c.text_len, c.html_len from (
+			select agenda_content_id, min(observed) as first from meeting_versions where agenda_content_id is not null group by agenda_content_id
+		) v
+		join meeting_agenda_content c on c.id=v.agenda_content_id
+		where v.first >= ? and v.first < ?
+		order by v.first`
+	rows, err := db.QueryContext(ctx, q, since, until)
+	if err != nil {
+		return fmt.Errorf("report: lengths: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		out          []lengthsRow
+		texts, htmls []int
+	)
+	flush := func(month string) {
+		if len(texts) == 0 {
+			return
+		}
+		slices.Sort(texts)
+		slices.Sort(htmls)
+		out = append(out, lengthsRow{
+			Month:      month,
+			Agendas:    len(texts),
+			TextP10:    percentile(texts, 10),
+			TextMedian: percentile(texts, 50),
+			TextP90:    percentile(texts, 90),
+			HTMLMedian: percentile(htmls, 50),
+		})
+		texts, htmls = nil, nil
+	}
+	var month string
+	for rows.Next() {
+		var (
+			m       string
+			textLen int
+			htmlLen sql.NullInt64
+		)
+		if err := rows.Scan(&m, &textLen, &htmlLen); err != nil {
+			return fmt.Errorf("report: lengths: %w", err)
+		}
+		if m != month {
+			flush(month)
+			month = m
+		}
+		texts = append(texts, textLen)
+		if htmlLen.Valid {
+			htmls = append(htmls, int(htmlLen.Int64))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("report: lengths: %w", err)
+	}
+	flush(month)
+
+	header := []string{"month", "agendas", "text_p10", "text_median", "text_p90", "html_median"}
+	err = writeResults(cfg.format, out, header, func(r lengthsRow) []string {
+		return []string{r.Month, strconv.Itoa(r.Agendas), strconv.Itoa(r.TextP10), strconv.Itoa(r.TextMedian), strconv.Itoa(r.TextP90), strconv.Itoa(r.HTMLMedian)}
+	})
+	if err != nil {
+		return fmt.Errorf("report: lengths: %w", err)
+	}
+	return nil
+}
+
+type methodRow struct {
+	Method     string  `json:"method"`
+	Contents   int     `json:"contents"`
+	Fraction   float64 `json:"fraction"` // of all external content
+	AvgTextLen int     `json:"avg_text_len"`
+}
+
+// extractionMethods counts external content by how its text was extracted,
+// with the average text length for each. Content stored before methods were
+// recorded is "unknown" until it's reprocessed, and content without text is
+// "ocr-skipped" or "none".
+func extractionMethods(ctx context.Context, db *sql.DB) ([]methodRow, error) {
+	const q = `select coalesce(method, case when ocr_skipped then 'ocr-skipped' when coalesce(text, '') = '' then 'none' else 'unknown' end) as m,
+		count(*), coalesce(avg(length(text)), 0)
+		from external_content group by m order by count(*) desc, m`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		out   []methodRow
+		total int
+	)
+	for rows.Next() {
+		var (
+			r      methodRow
+			avgLen float64
+		)
+		if err := rows.Scan(&r.Method, &r.Contents, &avgLen); err != nil {
+			return nil, err
+		}
+		r.AvgTextLen = int(avgLen + 0.5)
+		total += r.Contents
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Fraction = float64(out[i].Contents) / float64(total)
+	}
+	return out, nil
+}
+
+// reportMethods writes how much external content had its text extracted by
+// each method. OCR text is less reliable than a PDF's text layer, so the OCR
+// fraction is how much of the corpus is lower confidence.
+func reportMethods(ctx context.Context, db *sql.DB, cfg config) error {
+	out, err := extractionMethods(ctx, db)
+	if err != nil {
+		return fmt.Errorf("report: methods: %w", err)
+	}
+
+	header := []string{"method", "contents", "fraction", "avg_text_len"}
+	err = writeResults(cfg.format, out, header, func(r methodRow) []string {
+		return []string{r.Method, strconv.Itoa(r.Contents), strconv.FormatFloat(r.Fraction, 'f', 3, 64), strconv.Itoa(r.AvgTextLen)}
+	})
+	if err != nil {
+		return fmt.Errorf("report: methods: %w", err)
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank pth percentile of sorted, or 0 if it's
+// empty.
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (p*len(sorted)+99)/100 - 1
+	return sorted[max(i, 0)]
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestReportUntil(t *testing.T) {
+	db := testDB(t)
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	saveTestMeeting(t, db, "m1", starts, "Call to order", "")
+	// A version observed the day after -until is out of range.
+	after := starts.AddDate(0, 0, 1)
+	const q = `insert into meeting_versions (meeting_id, observed, schedule_note, agenda_url, minutes_url, video_url) values ('m1', ?, '', '', '', '')`
+	if _, err := db.Exec(q, newTimeValue(&after)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() error {
+		return report(context.Background(), db, nil, config{since: "2024-03-01", until: "2024-03-01"}, nil)
+	})
+	var rows []reportRow
+	if err := json.Unmarshal(out, &rows); err != nil {
+		t.Fatal(err)
+	}
+	want := []reportRow{
+		{Kind: "meetings", Month: "2024-03", Type: "Regional Council", Count: 1},
+		{Kind: "versions", Month: "2024-03", Type: "Regional Council", Count: 1},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %+v, want %+v", rows, want)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+}
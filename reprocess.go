@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"golang.org/x/time/rate"
+)
+
+// reprocessContent re-fetches already fetched external content URLs and runs
+// extraction again, for when extraction has improved. Original bytes aren't
+// kept, so the content has to be downloaded again.
+func reprocessContent(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if err := checkPDF(cfg.noOCR); err != nil {
+		return err
+	}
+
+	q := "select url from external_content_urls where external_content_id is not null"
+	var qargs []any
+	if cfg.contentType != "" {
+		q += " and content_type=?"
+		qargs = append(qargs, cfg.contentType)
+	}
+	q += " order by fetched"
+	if cfg.limit > 0 {
+		q += " limit ?"
+		qargs = append(qargs, cfg.limit)
+	}
+
+	rows, err := db.QueryContext(ctx, q, qargs...)
+	if err != nil {
+		return fmt.Errorf("reprocess content: select: %w", err)
+	}
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return fmt.Errorf("reprocess content: scan: %w", err)
+		}
+		urls = append(urls, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reprocess content: select: %w", err)
+	}
+
+	log.Println("reprocessing", len(urls), "external content urls")
+
+	for i, u := range urls {
+		if err := cfg.limiterWait.wait(ctx, limiter); err != nil {
+			return fmt.Errorf("reprocess %v: %w", u, err)
+		}
+		if err := processURL(ctx, db, cfg, u, true); err != nil {
+			return fmt.Errorf("reprocess %v: %w", u, err)
+		}
+
+		if (i+1)%10 == 0 {
+			log.Println("reprocessed", i+1, "/", len(urls), "external content urls")
+		}
+	}
+
+	log.Println("reprocessed", len(urls), "external content urls")
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Agenda HTML is scraped, so before it's stored or rendered it's reduced to
+// an allowlist of tags and attributes. Elements in sanitizeDropTags are
+// removed along with their contents; other elements not in sanitizeTags are
+// replaced by their children so their text is kept.
+var (
+	sanitizeTags = atomSet(
+		atom.A, atom.Abbr, atom.Article, atom.B, atom.Blockquote, atom.Br,
+		atom.Caption, atom.Center, atom.Cite, atom.Code, atom.Col, atom.Colgroup,
+		atom.Dd, atom.Del, atom.Div, atom.Dl, atom.Dt, atom.Em, atom.Figcaption,
+		atom.Figure, atom.Font, atom.Footer, atom.H1, atom.H2, atom.H3, atom.H4,
+		atom.H5, atom.H6, atom.Header, atom.Hr, atom.I, atom.Img, atom.Ins,
+		atom.Li, atom.Mark, atom.Ol, atom.P, atom.Pre, atom.Q, atom.S,
+		atom.Section, atom.Small, atom.Span, atom.Strong, atom.Sub, atom.Sup,
+		atom.Table, atom.Tbody, atom.Td, atom.Tfoot, atom.Th, atom.Thead,
+		atom.Tr, atom.U, atom.Ul,
+	)
+	sanitizeDropTags = atomSet(
+		atom.Applet, atom.Base, atom.Button, atom.Embed, atom.Form, atom.Frame,
+		atom.Frameset, atom.Iframe, atom.Input, atom.Link, atom.Math, atom.Meta,
+		atom.Noscript, atom.Object, atom.Script, atom.Select, atom.Style,
+		atom.Svg, atom.Template, atom.Textarea,
+	)
+	sanitizeAttrs = map[string]bool{
+		"align": true, "alt": true, "class": true, "colspan": true, "dir": true,
+		"headers": true, "height": true, "href": true, "id": true, "lang": true,
+		"rowspan": true, "scope": true, "src": true, "title": true,
+		"valign": true, "width": true,
+	}
+)
+
+func atomSet(as ...atom.Atom) map[atom.Atom]bool {
+	m := make(map[atom.Atom]bool, len(as))
+	for _, a := range as {
+		m[a] = true
+	}
+	return m
+}
+
+// sanitizedHTML returns the inner HTML of the first node in s, as
+// Selection.Html does, after sanitizing a copy of it. s is left as is.
+func sanitizedHTML(s *goquery.Selection) (string, error) {
+	if s.Length() == 0 {
+		return "", nil
+	}
+	c := s.First().Clone()
+	sanitizeNode(c.Nodes[0])
+	return c.Html()
+}
+
+// sanitizeHTML sanitizes the HTML fragment s, such as stored agenda HTML.
+func sanitizeHTML(s string) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	sanitizeNode(body)
+
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buf, c); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// sanitizeNode sanitizes the children of n in place.
+func sanitizeNode(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.CommentNode:
+			n.RemoveChild(c)
+		case html.ElementNode:
+			switch {
+			case c.Namespace != "" || sanitizeDropTags[c.DataAtom]:
+				n.RemoveChild(c)
+			case !sanitizeTags[c.DataAtom]:
+				sanitizeNode(c)
+				for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+				}
+				n.RemoveChild(c)
+			default:
+				c.Attr = sanitizeAttributes(c.Attr)
+				sanitizeNode(c)
+			}
+		}
+		c = next
+	}
+}
+
+func sanitizeAttributes(attrs []html.Attribute) []html.Attribute {
+	var out []html.Attribute
+	for _, a := range attrs {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !sanitizeAttrs[key] {
+			continue
+		}
+		if (key == "href" || key == "src") && !safeURL(a.Val, key == "href") {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// safeURL reports whether u is relative or uses a scheme that can't run
+// script. mailto is only allowed for links.
+func safeURL(u string, link bool) bool {
+	pu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(pu.Scheme) {
+	case "", "http", "https":
+		return true
+	case "mailto":
+		return link
+	}
+	return false
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+)
+
+type listRow struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Date         string `json:"date"`
+	Starts       string `json:"starts"`
+	Ends         string `json:"ends,omitempty"` // only known for some eScribe meetings
+	ScheduleNote string `json:"schedule_note"`
+	AgendaURL    string `json:"agenda_url"`
+	MinutesURL   string `json:"minutes_url"`
+	VideoURL     string `json:"video_url"`
+	Attachments  *int   `json:"attachment_count,omitempty"` // nil until the agenda is next fetched
+}
+
+// listMeetings writes meetings, optionally limited to -since and -until.
+func listMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	since, until := cfg.startsRange()
+
+	const q = `select id, type, coalesce(name, ''), starts, ends, schedule_note, agenda_url, minutes_url, video_url, attachment_count from meetings where starts >= ? and starts < ? order by starts, id`
+	rows, err := db.QueryContext(ctx, q, since, until)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	defer rows.Close()
+
+	var out []listRow
+	for rows.Next() {
+		var (
+			r            listRow
+			starts, ends time.Time
+		)
+		if err := rows.Scan(&r.ID, &r.Type, &r.Name, newTimeValue(&starts), newTimeValue(&ends), &r.ScheduleNote, &r.AgendaURL, &r.MinutesURL, &r.VideoURL, &r.Attachments); err != nil {
+			return fmt.Errorf("list: %w", err)
+		}
+		r.Date = starts.Format(dateFormat)
+		r.Starts = meetingTime(starts).In(cfg.tz).Format(time.RFC3339)
+		if !ends.IsZero() {
+			r.Ends = meetingTime(ends).In(cfg.tz).Format(time.RFC3339)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+
+	header := []string{"id", "type", "name", "date", "starts", "ends", "schedule_note", "agenda_url", "minutes_url", "video_url", "attachment_count"}
+	err = writeResults(cfg.format, out, header, func(r listRow) []string {
+		var attachments string
+		if r.Attachments != nil {
+			attachments = strconv.Itoa(*r.Attachments)
+		}
+		return []string{r.ID, r.Type, r.Name, r.Date, r.Starts, r.Ends, r.ScheduleNote, r.AgendaURL, r.MinutesURL, r.VideoURL, attachments}
+	})
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	return nil
+}
+
+type typeRow struct {
+	Type     string `json:"type"`
+	Meetings int    `json:"meetings"`
+	First    string `json:"first"`
+	Last     string `json:"last"`
+}
+
+// listTypes writes each meeting type with its meeting count and the dates of
+// its first and last meetings.
+func listTypes(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	const q = `select type, count(*), min(starts), max(starts) from meetings group by type order by type`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("types: %w", err)
+	}
+	defer rows.Close()
+
+	var out []typeRow
+	for rows.Next() {
+		var (
+			r           typeRow
+			first, last time.Time
+		)
+		if err := rows.Scan(&r.Type, &r.Meetings, newTimeValue(&first), newTimeValue(&last)); err != nil {
+			return fmt.Errorf("types: %w", err)
+		}
+		r.First = first.Format(dateFormat)
+		r.Last = last.Format(dateFormat)
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("types: %w", err)
+	}
+
+	header := []string{"type", "meetings", "first", "last"}
+	err = writeResults(cfg.format, out, header, func(r typeRow) []string {
+		return []string{r.Type, strconv.Itoa(r.Meetings), r.First, r.Last}
+	})
+	if err != nil {
+		return fmt.Errorf("types: %w", err)
+	}
+	return nil
+}
+
+// bm25 column weights for external content search. A title match usually
+// means the document is about the query, so it counts for more than a
+// mention in the body.
+const (
+	contentTitleWeight = 10.0
+	contentTextWeight  = 1.0
+)
+
+// meetingAgendaContent selects each meeting's searchable agenda content IDs
+// with the URL each was fetched from: its main agenda and any revised or
+// French ones.
+const meetingAgendaContent = `(
+		select id as meeting_id, agenda_content_id, agenda_url as url from meetings where agenda_content_id is not null
+		union select meeting_id, agenda_content_id, url from meeting_agendas where agenda_content_id is not null)`
+
+type searchRow struct {
+	Kind      string `json:"kind"` // agenda or content
+	MeetingID string `json:"meeting_id"`
+	Date      string `json:"date"`
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	Snippet   string `json:"snippet"`
+}
+
+// search runs the full text query given as arguments against agendas and
+// external content, writing matches with a snippet of the matching text.
+// External content is ranked with its title weighted over its text, see
+// contentTitleWeight. Results are limited by -limit, if set, and -since and
+// -until.
+func search(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	query := strings.Join(args, " ")
+	if strings.TrimSpace(query) == "" {
+		return errors.New("search: need a query")
+	}
+	since, until := cfg.startsRange()
+	limit := cfg.limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	const q = `select * from (
+		select 'agenda' as kind, m.id, m.starts, m.type, coalesce(m.name, m.type) as title, ma.url, snippet(meeting_agenda_content_search, 0, '', '', '…', 24) as snippet, s.rank
+		from meeting_agenda_content_search s
+		join meeting_agenda_content c on c.rowid=s.rowid
+		join ` + meetingAgendaContent + ` ma on ma.agenda_content_id=c.id
+		join meetings m on m.id=ma.meeting_id
+		where meeting_agenda_content_search match ?1 and m.starts >= ?2 and m.starts < ?3
+		union all
+		select distinct 'content', m.id, m.starts, m.type, coalesce(c.title, ''), u.url, snippet(external_content_search, 1, '', '', '…', 24), bm25(external_content_search, ?5, ?6)
+		from external_content_search s
+		join external_content c on c.rowid=s.rowid
+		join external_content_urls u on u.external_content_id=c.id
+		join meeting_external_content_urls mu on mu.external_content_url=u.url
+		join ` + meetingAgendaContent + ` ma on ma.meeting_id=mu.meeting_id and ma.agenda_content_id=mu.agenda_content_id
+		join meetings m on m.id=mu.meeting_id
+		where external_content_search match ?1 and m.starts >= ?2 and m.starts < ?3
+	) order by rank, starts desc limit ?4`
+	rows, err := db.QueryContext(ctx, q, query, since, until, limit, contentTitleWeight, contentTextWeight)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+
+	var out []searchRow
+	for rows.Next() {
+		var (
+			r      searchRow
+			starts time.Time
+			rank   float64
+		)
+		if err := rows.Scan(&r.Kind, &r.MeetingID, newTimeValue(&starts), &r.Type, &r.Title, &r.URL, &r.Snippet, &rank); err != nil {
+			return fmt.Errorf("search: %w", err)
+		}
+		r.Date = starts.Format(dateFormat)
+		r.Snippet = strings.Join(strings.Fields(r.Snippet), " ")
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+
+	header := []string{"kind", "meeting_id", "date", "type", "title", "url", "snippet"}
+	err = writeResults(cfg.format, out, header, func(r searchRow) []string {
+		return []string{r.Kind, r.MeetingID, r.Date, r.Type, r.Title, r.URL, r.Snippet}
+	})
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	return nil
+}
+
+type relatedRow struct {
+	MeetingID string  `json:"meeting_id"`
+	Type      string  `json:"type"`
+	Date      string  `json:"date"`
+	Score     float64 `json:"score"`
+}
+
+// relatedTerms is how many of a meeting's most distinctive agenda terms are
+// searched for to find related meetings.
+const relatedTerms = 20
+
+// relatedMeetings writes the meetings whose agendas are most similar to the
+// agenda of the meeting given as the argument. Similarity is the full text
+// rank of each agenda against the meeting's most distinctive terms, weighted
+// by how often each term appears in its agenda and how rare it is across all
+// agendas, revised and French ones included. The meeting itself and meetings sharing
+// the same agenda content are left out. Results are limited by -limit, 10 if
+// unset, and -since and -until.
+func relatedMeetings(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("related: need exactly one meeting id")
+	}
+	since, until := cfg.startsRange()
+	limit := cfg.limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	var contentID, text string
+	const cq = `select c.id, c.text from meetings m join meeting_agenda_content c on c.id=m.agenda_content_id where m.id=?`
+	if err := db.QueryRowContext(ctx, cq, args[0]).Scan(&contentID, &text); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("related: no agenda for meeting %v", args[0])
+	} else if err != nil {
+		return fmt.Errorf("related: %w", err)
+	}
+
+	terms, err := distinctiveTerms(ctx, db, text, relatedTerms)
+	if err != nil {
+		return fmt.Errorf("related: %w", err)
+	}
+	if len(terms) == 0 {
+		return fmt.Errorf("related: no terms in agenda for meeting %v", args[0])
+	}
+	for i, t := range terms {
+		terms[i] = `"` + t + `"`
+	}
+
+	// A meeting matching through more than one of its agendas is ranked by
+	// its best.
+	const q = `select m.id, m.type, m.starts, min(s.rank) as best
+		from meeting_agenda_content_search s
+		join meeting_agenda_content c on c.rowid=s.rowid
+		join ` + meetingAgendaContent + ` ma on ma.agenda_content_id=c.id
+		join meetings m on m.id=ma.meeting_id
+		where meeting_agenda_content_search match ?1 and c.id != ?2 and m.id != ?6 and m.starts >= ?3 and m.starts < ?4
+		group by m.id
+		order by best, m.starts desc limit ?5`
+	rows, err := db.QueryContext(ctx, q, strings.Join(terms, " OR "), contentID, since, until, limit, args[0])
+	if err != nil {
+		return fmt.Errorf("related: %w", err)
+	}
+	defer rows.Close()
+
+	var out []relatedRow
+	for rows.Next() {
+		var (
+			r      relatedRow
+			starts time.Time
+			rank   float64
+		)
+		if err := rows.Scan(&r.MeetingID, &r.Type, newTimeValue(&starts), &rank); err != nil {
+			return fmt.Errorf("related: %w", err)
+		}
+		r.Date = starts.Format(dateFormat)
+		// bm25 ranks better matches lower, and below zero.
+		r.Score = -rank
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("related: %w", err)
+	}
+
+	header := []string{"meeting_id", "type", "date", "score"}
+	err = writeResults(cfg.format, out, header, func(r relatedRow) []string {
+		return []string{r.MeetingID, r.Type, r.Date, strconv.FormatFloat(r.Score, 'g', -1, 64)}
+	})
+	if err != nil {
+		return fmt.Errorf("related: %w", err)
+	}
+	return nil
+}
+
+// distinctiveTerms returns up to n terms of text ordered by tf-idf, using
+// agenda document frequencies from the full text index's vocabulary. Terms
+// the index doesn't know, such as ones it would tokenize differently, and
+// numbers are skipped.
+func distinctiveTerms(ctx context.Context, db *sql.DB, text string, n int) ([]string, error) {
+	tf := make(map[string]int)
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(t) < 3 || strings.IndexFunc(t, unicode.IsLetter) < 0 {
+			continue
+		}
+		tf[t]++
+	}
+
+	var docs int
+	if err := db.QueryRowContext(ctx, "select count(*) from meeting_agenda_content").Scan(&docs); err != nil {
+		return nil, fmt.Errorf("count agendas: %w", err)
+	}
+
+	type scored struct {
+		term  string
+		score float64
+	}
+	var terms []scored
+	for t, c := range tf {
+		var df int
+		err := db.QueryRowContext(ctx, "select doc from meeting_agenda_content_vocab where term=?", t).Scan(&df)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("term %v frequency: %w", t, err)
+		}
+		terms = append(terms, scored{t, float64(c) * math.Log(float64(docs)/float64(df))})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].score != terms[j].score {
+			return terms[i].score > terms[j].score
+		}
+		return terms[i].term < terms[j].term
+	})
+
+	var out []string
+	for _, t := range terms[:min(n, len(terms))] {
+		// A term in every agenda says nothing about similarity.
+		if t.score <= 0 {
+			break
+		}
+		out = append(out, t.term)
+	}
+	return out, nil
+}
+
+// writeResults writes rows to stdout as a JSON array or, with format csv, as
+// a header row followed by one record per row. encoding/csv takes care of
+// quoting fields with commas, quotes or newlines.
+func writeResults[T any](format string, rows []T, header []string, record func(T) []string) error {
+	switch format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		for _, r := range rows {
+			w.Write(record(r))
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		if rows == nil {
+			rows = []T{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(rows)
+	}
+}
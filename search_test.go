@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func() error) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+	ferr := fn()
+	w.Close()
+	b := <-out
+	if ferr != nil {
+		t.Fatal(ferr)
+	}
+	return b
+}
+
+// saveTestMeeting stores a meeting with the given English agenda text as its
+// main agenda and French agenda text as a further revision.
+func saveTestMeeting(t *testing.T, db *sql.DB, id string, starts time.Time, en, fr string) {
+	t.Helper()
+	m := Meeting{
+		ID:    id,
+		Type:  "Regional Council",
+		Event: MeetingEvent{Date: starts},
+		URLs:  []MeetingURL{{"agenda", "https://example.com/" + id + "/en"}},
+	}
+	if _, err := saveMeeting(db, m, MeetingAgenda{ContentHTML: "<p>" + en + "</p>", ContentText: en, Language: "en"}, starts, 0); err != nil {
+		t.Fatal(err)
+	}
+	if fr == "" {
+		return
+	}
+	agenda := MeetingAgenda{ContentHTML: "<p>" + fr + "</p>", ContentText: fr, Language: "fr"}
+	if err := saveAgendaRevision(db, id, 1, "https://example.com/"+id+"/fr", agenda, starts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchFrenchAgendas(t *testing.T) {
+	db := testDB(t)
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	saveTestMeeting(t, db, "m1", starts, "Call to order and approval of the minutes", "Ouverture de la séance et adoption du procès-verbal")
+
+	out := captureStdout(t, func() error {
+		return search(context.Background(), db, nil, config{}, []string{"adoption"})
+	})
+	var rows []searchRow
+	if err := json.Unmarshal(out, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].MeetingID != "m1" || rows[0].URL != "https://example.com/m1/fr" {
+		t.Errorf("got %+v, want the French agenda of m1", rows)
+	}
+}
+
+func TestRelatedFrenchAgendas(t *testing.T) {
+	db := testDB(t)
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	saveTestMeeting(t, db, "m1", starts, "Budget committee", "Comité du budget et des finances")
+	saveTestMeeting(t, db, "m2", starts.AddDate(0, 0, 7), "Ouverture, comité des finances", "")
+	saveTestMeeting(t, db, "m3", starts.AddDate(0, 0, 14), "Transit planning", "Comité des finances et planification du transport")
+
+	out := captureStdout(t, func() error {
+		return relatedMeetings(context.Background(), db, nil, config{}, []string{"m2"})
+	})
+	var rows []relatedRow
+	if err := json.Unmarshal(out, &rows); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	for _, r := range rows {
+		seen[r.MeetingID]++
+	}
+	if seen["m1"] != 1 || seen["m3"] != 1 || seen["m2"] != 0 {
+		t.Errorf("got %+v, want m1 and m3 once each through their French agendas", rows)
+	}
+}
+
+func TestSearchRevisedAgendas(t *testing.T) {
+	db := testDB(t)
+	starts := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	saveTestMeeting(t, db, "m1", starts, "Call to order", "")
+	revised := MeetingAgenda{ContentHTML: "<p>Call to order, added item: snow removal</p>", ContentText: "Call to order, added item: snow removal", ContentURLs: []string{"https://example.com/snow.pdf"}}
+	if err := saveAgendaRevision(db, "m1", 1, "https://example.com/m1/revised", revised, starts); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() error {
+		return search(context.Background(), db, nil, config{}, []string{"snow"})
+	})
+	var rows []searchRow
+	if err := json.Unmarshal(out, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].URL != "https://example.com/m1/revised" {
+		t.Errorf("got %+v, want the revised agenda of m1", rows)
+	}
+
+	urls, err := meetingContentURLs(context.Background(), db, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].URL != "https://example.com/snow.pdf" {
+		t.Errorf("content URLs = %+v, want the revised agenda's", urls)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// siteSearchTextLen caps how much agenda text goes into search.json per
+// meeting to keep the index a reasonable size for browsers.
+const siteSearchTextLen = 10000
+
+type siteMeeting struct {
+	ID          string
+	Type        string
+	Date        string
+	Note        string
+	AgendaURL   string
+	MinutesURL  string
+	VideoURL    string
+	AgendaHTML  string
+	AgendaText  string
+	Attachments []siteAttachment
+}
+
+type siteAttachment struct {
+	URL   string
+	Title string
+}
+
+// Page is the meeting's page path relative to the site root.
+func (m siteMeeting) Page() string {
+	return "meetings/" + siteSlug(m.ID) + ".html"
+}
+
+func buildSite(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	if cfg.outputDir == "" {
+		return fmt.Errorf("build-site: need -output-dir")
+	}
+
+	meetings, err := siteMeetings(ctx, db)
+	if err != nil {
+		return fmt.Errorf("build-site: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(cfg.outputDir, "meetings"), 0o755); err != nil {
+		return fmt.Errorf("build-site: %w", err)
+	}
+
+	if err := writeTemplate(filepath.Join(cfg.outputDir, "index.html"), siteIndexTmpl, meetings); err != nil {
+		return fmt.Errorf("build-site: index: %w", err)
+	}
+
+	for _, m := range meetings {
+		if err := writeTemplate(filepath.Join(cfg.outputDir, m.Page()), siteMeetingTmpl, m); err != nil {
+			return fmt.Errorf("build-site: meeting %v: %w", m.ID, err)
+		}
+	}
+
+	type searchEntry struct {
+		Page string `json:"page"`
+		Type string `json:"type"`
+		Date string `json:"date"`
+		Text string `json:"text"`
+	}
+	var entries []searchEntry
+	for _, m := range meetings {
+		text := m.AgendaText
+		if len(text) > siteSearchTextLen {
+			text = strings.ToValidUTF8(text[:siteSearchTextLen], "")
+		}
+		entries = append(entries, searchEntry{m.Page(), m.Type, m.Date, text})
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("build-site: marshal search index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.outputDir, "search.json"), b, 0o644); err != nil {
+		return fmt.Errorf("build-site: %w", err)
+	}
+
+	log.Println("built site with", len(meetings), "meetings in", cfg.outputDir)
+	return nil
+}
+
+func siteMeetings(ctx context.Context, db *sql.DB) ([]siteMeeting, error) {
+	const q = `select m.id, m.type, m.starts, m.schedule_note, m.agenda_url, m.minutes_url, m.video_url, coalesce(c.html, ''), coalesce(c.text, '') from meetings m left join meeting_agenda_content c on c.id=m.agenda_content_id order by m.starts desc, m.type`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("select meetings: %w", err)
+	}
+	defer rows.Close()
+
+	var meetings []siteMeeting
+	for rows.Next() {
+		var (
+			m      siteMeeting
+			starts time.Time
+		)
+		if err := rows.Scan(&m.ID, &m.Type, newTimeValue(&starts), &m.Note, &m.AgendaURL, &m.MinutesURL, &m.VideoURL, &m.AgendaHTML, &m.AgendaText); err != nil {
+			return nil, fmt.Errorf("scan meetings: %w", err)
+		}
+		// Agendas stored before sanitizing was added may still have
+		// scripts and the like.
+		agendaHTML, err := sanitizeHTML(m.AgendaHTML)
+		if err != nil {
+			return nil, fmt.Errorf("sanitize agenda for %v: %w", m.ID, err)
+		}
+		m.AgendaHTML = agendaHTML
+		m.Date = starts.Format(dateFormat)
+		meetings = append(meetings, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select meetings: %w", err)
+	}
+
+	for i, m := range meetings {
+		// Attachments of revised agendas follow the main agenda's.
+		const aq = `select u.url, coalesce(c.title, mu.title, '') from meeting_external_content_urls mu join ` + meetingAgendaRevisions + ` a on a.agenda_content_id=mu.agenda_content_id join external_content_urls u on u.url=mu.external_content_url left join external_content c on c.id=u.external_content_id where mu.meeting_id=?1 order by a.revision, mu.sequence is null, mu.sequence, u.url`
+		rows, err := db.QueryContext(ctx, aq, m.ID)
+		if err != nil {
+			return nil, fmt.Errorf("select attachments for %v: %w", m.ID, err)
+		}
+		seen := make(map[string]bool)
+		for rows.Next() {
+			var a siteAttachment
+			if err := rows.Scan(&a.URL, &a.Title); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan attachments for %v: %w", m.ID, err)
+			}
+			if seen[a.URL] {
+				continue
+			}
+			seen[a.URL] = true
+			meetings[i].Attachments = append(meetings[i].Attachments, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("select attachments for %v: %w", m.ID, err)
+		}
+	}
+
+	return meetings, nil
+}
+
+func writeTemplate(fn string, t *template.Template, data any) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// siteSlug turns a meeting ID, which may contain slashes and other URL
+// characters, into something safe to use as a file name.
+func siteSlug(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, id)
+}
+
+var siteIndexTmpl = template.Must(template.New("index").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Halifax Regional Municipality meetings</title>
+</head>
+<body>
+<h1>Halifax Regional Municipality meetings</h1>
+<p><input id="q" type="search" placeholder="Search agendas"></p>
+<ul id="results"></ul>
+<table>
+<thead><tr><th>Date</th><th>Type</th><th>Note</th></tr></thead>
+<tbody>
+{{range .}}<tr><td>{{.Date}}</td><td><a href="{{.Page}}">{{.Type}}</a></td><td>{{.Note}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+let index;
+const q = document.getElementById("q");
+const results = document.getElementById("results");
+q.addEventListener("input", async () => {
+  if (!index) {
+    index = await (await fetch("search.json")).json();
+  }
+  results.replaceChildren();
+  const terms = q.value.toLowerCase().split(/\s+/).filter(t => t);
+  if (terms.length === 0) {
+    return;
+  }
+  for (const e of index) {
+    const hay = (e.type + " " + e.text).toLowerCase();
+    if (!terms.every(t => hay.includes(t))) {
+      continue;
+    }
+    const a = document.createElement("a");
+    a.href = e.page;
+    a.textContent = e.date + " " + e.type;
+    const li = document.createElement("li");
+    li.append(a);
+    results.append(li);
+  }
+});
+</script>
+</body>
+</html>
+`))
+
+// The agenda HTML is scraped, so it's only ever placed in a sandboxed
+// iframe's srcdoc where html/template escapes it as an attribute value.
+var siteMeetingTmpl = template.Must(template.New("meeting").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Type}} {{.Date}}</title>
+</head>
+<body>
+<p><a href="../index.html">All meetings</a></p>
+<h1>{{.Type}}</h1>
+<p>{{.Date}}{{with .Note}} ({{.}}){{end}}</p>
+<ul>
+{{with .AgendaURL}}<li><a href="{{.}}">Agenda</a></li>{{end}}
+{{with .MinutesURL}}<li><a href="{{.}}">Minutes</a></li>{{end}}
+{{with .VideoURL}}<li><a href="{{.}}">Video</a></li>{{end}}
+</ul>
+{{with .Attachments}}<h2>Attachments</h2>
+<ul>
+{{range .}}<li><a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></li>
+{{end}}</ul>
+{{end}}
+<h2>Agenda</h2>
+{{if .AgendaHTML}}<iframe sandbox srcdoc="{{.AgendaHTML}}" style="width: 100%; height: 80vh; border: 0"></iframe>
+{{else}}<pre style="white-space: pre-wrap">{{.AgendaText}}</pre>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func printStats(ctx context.Context, db *sql.DB, limiter *rate.Limiter, cfg config, args []string) error {
+	counts := []struct {
+		name string
+		q    string
+	}{
+		{"meetings", "select count(*) from meetings"},
+		{"meeting versions", "select count(*) from meeting_versions"},
+		{"agenda contents", "select count(*) from meeting_agenda_content"},
+		{"external content urls", "select count(*) from external_content_urls"},
+		{"external content urls fetched", "select count(*) from external_content_urls where fetched is not null"},
+		{"external content urls errored", "select count(*) from external_content_urls where error is not null"},
+		{"external content urls encrypted", "select count(*) from external_content_urls where error_kind='encrypted'"},
+		{"external content urls corrupt", "select count(*) from external_content_urls where error_kind='corrupt'"},
+		{"external contents", "select count(*) from external_content"},
+		{"external content urls checked", "select count(*) from external_content_urls where last_checked is not null"},
+		{"external content urls dead", "select count(*) from external_content_urls where last_status in (" + deadStatusList() + ")"},
+	}
+	for _, c := range counts {
+		var n int64
+		if err := db.QueryRowContext(ctx, c.q).Scan(&n); err != nil {
+			return fmt.Errorf("stats: %v: %w", c.name, err)
+		}
+		fmt.Printf("%v: %v\n", c.name, n)
+	}
+
+	cov, err := agendaCoverage(ctx, db, time.Now())
+	if err != nil {
+		return fmt.Errorf("stats: agenda coverage: %w", err)
+	}
+	fmt.Printf("agenda coverage last %v days: %v\n", coverageDays, cov)
+	cfg.summary.add("coverage_meetings", cov.meetings)
+	cfg.summary.add("coverage_with_agenda", cov.withAgenda)
+
+	methods, err := extractionMethods(ctx, db)
+	if err != nil {
+		return fmt.Errorf("stats: extraction methods: %w", err)
+	}
+	for _, m := range methods {
+		fmt.Printf("external contents extracted by %v: %v (%.1f%%) avg text=%v\n", m.Method, m.Contents, 100*m.Fraction, m.AvgTextLen)
+		cfg.summary.add("method_"+m.Method, m.Contents)
+	}
+
+	arows, err := db.QueryContext(ctx, "select action, started, finished, limiter_wait_ms, coalesce(counts, '') from action_runs where rowid in (select max(rowid) from action_runs group by action) order by action")
+	if err != nil {
+		return fmt.Errorf("stats: action runs: %w", err)
+	}
+	defer arows.Close()
+	for arows.Next() {
+		var (
+			action            string
+			started, finished time.Time
+			waitMS            int64
+			countsJSON        string
+		)
+		if err := arows.Scan(&action, newTimeValue(&started), newTimeValue(&finished), &waitMS, &countsJSON); err != nil {
+			return fmt.Errorf("stats: action runs: %w", err)
+		}
+		var counts runSummary
+		if countsJSON != "" {
+			if err := json.Unmarshal([]byte(countsJSON), &counts.counts); err != nil {
+				return fmt.Errorf("stats: action run counts: %w", err)
+			}
+		}
+		took := finished.Sub(started)
+		wait := time.Duration(waitMS) * time.Millisecond
+		var pct float64
+		if took > 0 {
+			pct = 100 * wait.Seconds() / took.Seconds()
+		}
+		fmt.Printf("last %v run: started=%v took=%v limiter wait=%v (%.0f%%)", action, started.In(cfg.tz).Format(time.RFC3339), took.Round(time.Second), wait.Round(time.Second), pct)
+		if c := counts.String(); c != "" {
+			fmt.Printf(" %v", c)
+		}
+		fmt.Println()
+	}
+	if err := arows.Err(); err != nil {
+		return fmt.Errorf("stats: action runs: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "select url, last_status, last_checked from external_content_urls where last_status in ("+deadStatusList()+") order by url")
+	if err != nil {
+		return fmt.Errorf("stats: dead links: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			u       string
+			status  int
+			checked time.Time
+		)
+		if err := rows.Scan(&u, &status, newTimeValue(&checked)); err != nil {
+			return fmt.Errorf("stats: dead links: %w", err)
+		}
+		fmt.Printf("dead link: %v status=%v checked=%v\n", u, status, checked.In(cfg.tz).Format(time.RFC3339))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("stats: dead links: %w", err)
+	}
+	return nil
+}
+
+// coverageDays is how far back agendaCoverage looks.
+const coverageDays = 30
+
+// coverage counts the meetings in a window by how much of their agenda is
+// stored.
+type coverage struct {
+	meetings   int
+	withAgenda int // non-empty agenda text stored
+	noContent  int // agenda URL but no text stored, not fetched yet, failed or too short
+	noURL      int // no agenda URL listed
+}
+
+func (c coverage) String() string {
+	pct := 100.0
+	if c.meetings > 0 {
+		pct = 100 * float64(c.withAgenda) / float64(c.meetings)
+	}
+	return fmt.Sprintf("%.1f%% meetings=%v with-agenda=%v no-content=%v no-agenda-url=%v", pct, c.meetings, c.withAgenda, c.noContent, c.noURL)
+}
+
+// agendaCoverage counts the meetings starting in the coverageDays before now
+// by whether their agenda text is stored. The with-agenda fraction is a
+// single number for whether fetching is keeping up.
+func agendaCoverage(ctx context.Context, db *sql.DB, now time.Time) (coverage, error) {
+	since := now.AddDate(0, 0, -coverageDays)
+	const q = `select count(*),
+		coalesce(sum(coalesce(c.text, '') != ''), 0),
+		coalesce(sum(coalesce(m.agenda_url, '') != '' and coalesce(c.text, '') = ''), 0),
+		coalesce(sum(coalesce(m.agenda_url, '') = ''), 0)
+		from meetings m left join meeting_agenda_content c on c.id=m.agenda_content_id
+		where m.starts >= ? and m.starts < ?`
+	var c coverage
+	if err := db.QueryRowContext(ctx, q, newTimeValue(&since), newTimeValue(&now)).Scan(&c.meetings, &c.withAgenda, &c.noContent, &c.noURL); err != nil {
+		return coverage{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// runSummary counts what an action did, such as meetings listed or urls
+// fetched, for the end-of-run summary and action_runs.
+type runSummary struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// add adds n to the count called name. s may be nil.
+func (s *runSummary) add(name string, n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[name] += n
+}
+
+func (s *runSummary) get(name string) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[name]
+}
+
+// String returns the counts as space-separated name=count pairs, sorted by
+// name.
+func (s *runSummary) String() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.counts))
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%v=%v", name, s.counts[name])
+	}
+	return b.String()
+}
+
+// JSON returns the counts as a JSON object, or "" if there are none.
+func (s *runSummary) JSON() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.counts) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(s.counts)
+	return string(b)
+}
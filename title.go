@@ -0,0 +1,68 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const docxContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+// titleFor returns the best available title for fetched content: one from
+// the content's own metadata, then extracted (such as a PDF's title), then
+// the text of the link that pointed to it.
+func titleFor(uc urlContent, extracted, linkText string) string {
+	mediaType, _, _ := mime.ParseMediaType(uc.contentType)
+
+	var title string
+	switch mediaType {
+	case docxContentType:
+		title, _ = docxTitle(uc.f, uc.size)
+	case "text/html":
+		title, _ = htmlTitle(uc.f)
+	}
+
+	for _, t := range []string{title, extracted, linkText} {
+		if t = strings.TrimSpace(t); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+func docxTitle(r io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", fmt.Errorf("open docx: %w", err)
+	}
+	f, err := zr.Open("docProps/core.xml")
+	if err != nil {
+		return "", fmt.Errorf("open core properties: %w", err)
+	}
+	defer f.Close()
+
+	var core struct {
+		Title string `xml:"title"`
+	}
+	if err := xml.NewDecoder(f).Decode(&core); err != nil {
+		return "", fmt.Errorf("decode core properties: %w", err)
+	}
+	return core.Title, nil
+}
+
+func htmlTitle(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return "", fmt.Errorf("new document: %w", err)
+	}
+	title := doc.Find("head > title").First().Text()
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(title), "| Halifax.ca")), nil
+}
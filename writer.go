@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dbWriter runs database writes one at a time on a single goroutine. SQLite
+// only allows one writer, so work that fetches or extracts concurrently hands
+// its writes to a dbWriter instead of contending for the write lock with
+// overlapping transactions.
+type dbWriter struct {
+	db   *sql.DB
+	reqs chan dbWrite
+	done chan struct{}
+}
+
+type dbWrite struct {
+	fn  func(*sql.DB) error
+	res chan error
+}
+
+func newDBWriter(db *sql.DB) *dbWriter {
+	w := &dbWriter{db: db, reqs: make(chan dbWrite), done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+func (w *dbWriter) run() {
+	defer close(w.done)
+	for r := range w.reqs {
+		r.res <- retryBusy(func() error { return r.fn(w.db) })
+	}
+}
+
+// do runs fn on the writer goroutine and returns its error. Calls from
+// multiple goroutines are serialized. fn is run again if it fails because
+// the database is busy, so it should do its writes in one transaction.
+func (w *dbWriter) do(fn func(*sql.DB) error) error {
+	res := make(chan error, 1)
+	w.reqs <- dbWrite{fn: fn, res: res}
+	return <-res
+}
+
+// Close waits for pending writes to finish. do must not be called after.
+func (w *dbWriter) Close() {
+	close(w.reqs)
+	<-w.done
+}
+
+// retryBusy calls fn until it succeeds, fails with an error other than busy
+// or locked, or has been tried a few times. busy_timeout already waits for
+// the lock within a statement; this covers another process, such as a
+// backup, holding it for longer than that.
+func retryBusy(fn func() error) error {
+	const attempts = 5
+	wait := time.Second
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || !isBusy(err) || i == attempts {
+			return err
+		}
+		log.Printf("database busy, retrying attempt=%v wait=%v err=%v", i, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func isBusy(err error) bool {
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+	// Extended codes such as SQLITE_BUSY_SNAPSHOT keep the primary code in
+	// the low byte.
+	switch serr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}